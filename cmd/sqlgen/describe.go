@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// describedQuery is the query with the types of its parameters and columns.
+type describedQuery struct {
+	query
+
+	params  []field
+	columns []field
+}
+
+// field is a parameter of the method or a field of the row.
+type field struct {
+	name   string
+	goType string
+}
+
+// goTypes maps the types of Postgres to the types of Go scanned by the pgx
+// driver of database/sql.
+var goTypes = map[uint32]string{
+	pgtype.BoolOID:        "bool",
+	pgtype.Int2OID:        "int16",
+	pgtype.Int4OID:        "int32",
+	pgtype.Int8OID:        "int64",
+	pgtype.Float4OID:      "float32",
+	pgtype.Float8OID:      "float64",
+	pgtype.TextOID:        "string",
+	pgtype.VarcharOID:     "string",
+	pgtype.BPCharOID:      "string",
+	pgtype.NameOID:        "string",
+	pgtype.UUIDOID:        "uuid.UUID",
+	pgtype.TimestamptzOID: "time.Time",
+	pgtype.TimestampOID:   "time.Time",
+	pgtype.DateOID:        "time.Time",
+	pgtype.ByteaOID:       "[]byte",
+	pgtype.JSONOID:        "[]byte",
+	pgtype.JSONBOID:       "[]byte",
+}
+
+// describe infers the types of the parameters and the columns of the query by
+// PREPARE of the unnamed statement, the query is not executed.
+func describe(ctx context.Context, conn *pgx.Conn, q query) (describedQuery, error) {
+	sd, err := conn.Prepare(ctx, "", q.sql)
+	if err != nil {
+		return describedQuery{}, fmt.Errorf("%s: failed to describe %s: %w", q.pos, q.name, err)
+	}
+
+	if len(q.params) > 0 && len(q.params) != len(sd.ParamOIDs) {
+		const format = "%s: %s has %d parameter names, but the query has %d parameters"
+		return describedQuery{}, fmt.Errorf(format, q.pos, q.name, len(q.params), len(sd.ParamOIDs))
+	}
+
+	switch returns := len(sd.Fields) > 0; {
+	case returns && (q.kind == kindExec || q.kind == kindExecRows):
+		return describedQuery{}, fmt.Errorf("%s: %s returns rows, it is not %s", q.pos, q.name, q.kind)
+	case !returns && (q.kind == kindOne || q.kind == kindMany):
+		return describedQuery{}, fmt.Errorf("%s: %s returns no rows, it is not %s", q.pos, q.name, q.kind)
+	}
+
+	described := describedQuery{query: q}
+
+	for i, oid := range sd.ParamOIDs {
+		name := fmt.Sprintf("arg%d", i+1)
+		if len(q.params) > 0 {
+			name = q.params[i]
+		}
+
+		goType, err := goType(conn, oid, false)
+		if err != nil {
+			return describedQuery{}, fmt.Errorf("%s: parameter %s of %s: %w", q.pos, name, q.name, err)
+		}
+
+		described.params = append(described.params, field{name: name, goType: goType})
+	}
+
+	for _, f := range sd.Fields {
+		name := exportedName(f.Name)
+
+		if slices.ContainsFunc(described.columns, func(c field) bool { return c.name == name }) {
+			const format = "%s: %s has duplicate column %s, use an alias"
+			return describedQuery{}, fmt.Errorf(format, q.pos, q.name, f.Name)
+		}
+
+		nullable, err := isNullable(ctx, conn, f)
+		if err != nil {
+			return describedQuery{}, fmt.Errorf("%s: column %s of %s: %w", q.pos, f.Name, q.name, err)
+		}
+
+		goType, err := goType(conn, f.DataTypeOID, nullable)
+		if err != nil {
+			return describedQuery{}, fmt.Errorf("%s: column %s of %s: %w", q.pos, f.Name, q.name, err)
+		}
+
+		described.columns = append(described.columns, field{name: name, goType: goType})
+	}
+
+	return described, nil
+}
+
+// goType returns the type of Go of the type of Postgres, nullable values are
+// pointers, except slices which are nil for NULL.
+func goType(conn *pgx.Conn, oid uint32, nullable bool) (string, error) {
+	goType, ok := goTypes[oid]
+	if !ok {
+		name := fmt.Sprintf("with OID %d", oid)
+		if t, ok := conn.TypeMap().TypeForOID(oid); ok {
+			name = t.Name
+		}
+
+		return "", fmt.Errorf("unsupported type %s", name)
+	}
+
+	if nullable && !strings.HasPrefix(goType, "[]") {
+		return "*" + goType, nil
+	}
+
+	return goType, nil
+}
+
+// isNullable reports whether the column may be NULL. Postgres describes the
+// nullability only of columns of tables, other columns are nullable.
+func isNullable(ctx context.Context, conn *pgx.Conn, f pgconn.FieldDescription) (bool, error) {
+	if f.TableOID == 0 {
+		return true, nil
+	}
+
+	const sql = `SELECT attnotnull FROM pg_attribute WHERE attrelid = $1 AND attnum = $2;`
+
+	var notNull bool
+
+	err := conn.QueryRow(ctx, sql, f.TableOID, f.TableAttributeNumber).Scan(&notNull)
+	if err != nil {
+		return false, err
+	}
+
+	return !notNull, nil
+}
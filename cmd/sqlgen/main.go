@@ -0,0 +1,160 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command sqlgen generates typed Go methods from annotated SQL queries, so
+// that parameters and scanned columns follow the schema of the migrations.
+//
+// Every query of the SQL files given as arguments starts with its name and
+// kind, it may be followed by the names of its parameters in order of their
+// placeholders and by the doc comment of the method:
+//
+//	-- name: ReadUser :one
+//	-- param: userID
+//	-- ReadUser returns the user by its ID.
+//	SELECT user_id, username, deleted_at FROM users WHERE user_id = $1;
+//
+// The kinds are :one, :many, :exec and :execrows. The types are inferred by
+// Postgres: the up migrations are applied to an empty database provisioned by
+// testingpg from template0, and every query is described by PREPARE. Columns
+// of tables are nullable as they are declared, other columns, like results of
+// expressions, are always nullable and scanned to pointers. Columns of the
+// optional side of outer joins have to be cast, like deleted_at::timestamptz,
+// to be nullable.
+//
+// The generated methods use QueryContext, QueryRowContext and ExecContext, so
+// they work on *sql.DB, *sql.Tx and the DB of the repository.
+//
+// Usage:
+//
+//	go run ./cmd/sqlgen -package users -out queries.sql.go queries/*.sql
+//
+// The server is set by TESTING_DB_URL like for tests.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type config struct {
+	migrations string
+	pkg        string
+	typeName   string
+	out        string
+	verbose    bool
+	files      []string
+}
+
+func main() {
+	cfg := config{}
+
+	flag.StringVar(&cfg.migrations, "migrations", "migrations", "directory of the migrations")
+	flag.StringVar(&cfg.pkg, "package", "", "package of the generated file")
+	flag.StringVar(&cfg.typeName, "type", "Queries", "type of the generated methods")
+	flag.StringVar(&cfg.out, "out", "", "generated file, by default it is written to stdout")
+	flag.BoolVar(&cfg.verbose, "v", false, "log the provisioning of the database")
+	flag.Parse()
+
+	cfg.files = flag.Args()
+
+	if err := run(context.Background(), cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "sqlgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg config) error {
+	if cfg.pkg == "" || len(cfg.files) == 0 {
+		return errors.New("the package and at least one SQL file are required")
+	}
+
+	src, err := generate(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.out == "" {
+		_, err := os.Stdout.Write(src)
+		return err
+	}
+
+	return os.WriteFile(cfg.out, src, 0o644)
+}
+
+// generate returns the source of the methods of the queries of cfg.
+func generate(ctx context.Context, cfg config) (_ []byte, err error) {
+	queries := make([]query, 0)
+
+	for _, file := range cfg.files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := parseQueries(file, string(src))
+		if err != nil {
+			return nil, err
+		}
+
+		queries = append(queries, parsed...)
+	}
+
+	t := &generatorT{verbose: cfg.verbose}
+	defer func() { err = errors.Join(err, t.cleanup()) }()
+
+	conn, err := provision(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyMigrations(ctx, conn, cfg.migrations); err != nil {
+		return nil, err
+	}
+
+	described := make([]describedQuery, 0, len(queries))
+
+	for _, q := range queries {
+		d, err := describe(ctx, conn, q)
+		if err != nil {
+			return nil, err
+		}
+
+		described = append(described, d)
+	}
+
+	return render(cfg.pkg, cfg.typeName, described)
+}
+
+// applyMigrations applies the up migrations of dir in order of their
+// versions, every migration is applied by a single statement like
+// golang-migrate does.
+func applyMigrations(ctx context.Context, conn *pgx.Conn, dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no up migrations in %s", dir)
+	}
+
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if _, err := conn.Exec(ctx, string(sql)); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", filepath.Base(file), err)
+		}
+	}
+
+	return nil
+}
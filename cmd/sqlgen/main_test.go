@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_generate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Types are inferred from the migrations", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		cfg := config{
+			migrations: filepath.Join("..", "..", "migrations"),
+			pkg:        "users",
+			typeName:   "Queries",
+			files:      []string{filepath.Join("testdata", "users.sql")},
+		}
+
+		// Act
+		src, err := generate(context.Background(), cfg)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = parser.ParseFile(token.NewFileSet(), "queries.sql.go", src, 0)
+		require.NoError(t, err)
+
+		for _, want := range []string{
+			"func (q *Queries) ReadUser(ctx context.Context, userID uuid.UUID) (ReadUserRow, error)",
+			"\tUsername  string\n",
+			"\tVersion   int64\n",
+			"\tDeletedAt *time.Time\n",
+			"\tTotal *int64\n",
+			"ListUsers(ctx context.Context, afterID uuid.UUID, size int64) ([]ListUsersRow, error)",
+			"CreateUser(ctx context.Context, userID uuid.UUID, username string, createdAt time.Time) error",
+			"PurgeDeletedUsers(ctx context.Context, arg1 time.Time) (int64, error)",
+		} {
+			require.Contains(t, string(src), want)
+		}
+	})
+
+	t.Run("Errors of the queries are reported with their position", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		file := filepath.Join(t.TempDir(), "broken.sql")
+		src := []byte("-- name: Broken :one\nSELECT nope FROM users;")
+		require.NoError(t, os.WriteFile(file, src, 0o600))
+
+		cfg := config{
+			migrations: filepath.Join("..", "..", "migrations"),
+			pkg:        "users",
+			typeName:   "Queries",
+			files:      []string{file},
+		}
+
+		// Act
+		_, err := generate(context.Background(), cfg)
+
+		// Assert
+		require.ErrorContains(t, err, file+":1: failed to describe Broken")
+	})
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+// Kinds of queries, they set the signature of the generated method.
+const (
+	// kindOne returns the row, sql.ErrNoRows is returned if there is no row.
+	kindOne = ":one"
+	// kindMany returns all rows.
+	kindMany = ":many"
+	// kindExec returns only the error.
+	kindExec = ":exec"
+	// kindExecRows returns the number of affected rows.
+	kindExecRows = ":execrows"
+)
+
+// reservedNames are the names of the variables of the generated methods,
+// they cannot be used as names of parameters.
+var reservedNames = []string{"ctx", "q", "item", "items", "rows", "result", "err"}
+
+// query is an annotated query of a SQL file.
+type query struct {
+	name   string
+	kind   string
+	params []string
+	doc    []string
+	sql    string
+	// pos is the file and the line of the name of the query.
+	pos string
+}
+
+// parseQueries returns the queries of the SQL file. Lines before the first
+// query may be only comments and blank lines.
+func parseQueries(file, src string) ([]query, error) {
+	queries := make([]query, 0)
+
+	var current *query
+
+	line := 0
+
+	for text := range strings.Lines(src) {
+		line++
+
+		text = strings.TrimRight(text, " \t\r\n")
+		comment, isComment := strings.CutPrefix(strings.TrimSpace(text), "--")
+		comment = strings.TrimSpace(comment)
+
+		if rest, ok := strings.CutPrefix(comment, "name:"); isComment && ok {
+			q, err := parseName(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+			}
+
+			q.pos = fmt.Sprintf("%s:%d", file, line)
+			queries = append(queries, q)
+			current = &queries[len(queries)-1]
+
+			continue
+		}
+
+		switch {
+		case current == nil && (isComment || text == ""):
+		case current == nil:
+			return nil, fmt.Errorf("%s:%d: SQL before the name of the query", file, line)
+		case current.sql == "" && isComment:
+			if param, ok := strings.CutPrefix(comment, "param:"); ok {
+				param = strings.TrimSpace(param)
+				if !token.IsIdentifier(param) ||
+					slices.Contains(reservedNames, param) ||
+					slices.Contains(current.params, param) {
+					return nil, fmt.Errorf("%s:%d: invalid parameter name %q", file, line, param)
+				}
+
+				current.params = append(current.params, param)
+
+				continue
+			}
+
+			current.doc = append(current.doc, comment)
+		case current.sql == "" && text == "":
+		default:
+			current.sql += text + "\n"
+		}
+	}
+
+	names := make([]string, 0, len(queries))
+
+	for i := range queries {
+		q := &queries[i]
+		q.sql = strings.TrimSpace(q.sql)
+
+		if q.sql == "" {
+			return nil, fmt.Errorf("%s: query %s has no SQL", q.pos, q.name)
+		}
+
+		if slices.Contains(names, q.name) {
+			return nil, fmt.Errorf("%s: duplicate query %s", q.pos, q.name)
+		}
+
+		names = append(names, q.name)
+	}
+
+	return queries, nil
+}
+
+// parseName parses the name and the kind of the query, like "ReadUser :one".
+func parseName(s string) (query, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return query{}, fmt.Errorf("want the name and the kind of the query, got %q", s)
+	}
+
+	name, kind := fields[0], fields[1]
+
+	if !token.IsExported(name) || !token.IsIdentifier(name) {
+		return query{}, fmt.Errorf("name of the query %q is not an exported identifier", name)
+	}
+
+	if !slices.Contains([]string{kindOne, kindMany, kindExec, kindExecRows}, kind) {
+		return query{}, fmt.Errorf("unknown kind %s of the query %s", kind, name)
+	}
+
+	return query{name: name, kind: kind}, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseQueries(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Queries are parsed with their parameters and docs", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		const src = `-- The header is ignored.
+
+-- name: ReadUser :one
+-- param: userID
+-- ReadUser returns the user.
+SELECT user_id
+-- The comment is a part of the query.
+FROM users WHERE user_id = $1;
+
+-- name: DeleteUsers :execrows
+DELETE FROM users;
+`
+
+		// Act
+		queries, err := parseQueries("users.sql", src)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []query{
+			{
+				name:   "ReadUser",
+				kind:   kindOne,
+				params: []string{"userID"},
+				doc:    []string{"ReadUser returns the user."},
+				sql: "SELECT user_id\n" +
+					"-- The comment is a part of the query.\n" +
+					"FROM users WHERE user_id = $1;",
+				pos: "users.sql:3",
+			},
+			{
+				name: "DeleteUsers",
+				kind: kindExecRows,
+				sql:  "DELETE FROM users;",
+				pos:  "users.sql:10",
+			},
+		}, queries)
+	})
+
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "SQL before the first query",
+			src:     "SELECT 1;\n-- name: One :one\nSELECT 1;",
+			wantErr: "users.sql:1: SQL before the name of the query",
+		},
+		{
+			name:    "Unknown kind",
+			src:     "-- name: One :first\nSELECT 1;",
+			wantErr: "users.sql:1: unknown kind :first of the query One",
+		},
+		{
+			name:    "Unexported name",
+			src:     "-- name: one :one\nSELECT 1;",
+			wantErr: `users.sql:1: name of the query "one" is not an exported identifier`,
+		},
+		{
+			name:    "Missing kind",
+			src:     "-- name: One\nSELECT 1;",
+			wantErr: `users.sql:1: want the name and the kind of the query, got " One"`,
+		},
+		{
+			name:    "Invalid parameter name",
+			src:     "-- name: One :one\n-- param: type\nSELECT $1::int;",
+			wantErr: `users.sql:2: invalid parameter name "type"`,
+		},
+		{
+			name:    "Reserved parameter name",
+			src:     "-- name: One :one\n-- param: ctx\nSELECT $1::int;",
+			wantErr: `users.sql:2: invalid parameter name "ctx"`,
+		},
+		{
+			name:    "Query without SQL",
+			src:     "-- name: One :one\n-- name: Two :one\nSELECT 2;",
+			wantErr: "users.sql:1: query One has no SQL",
+		},
+		{
+			name:    "Duplicate query",
+			src:     "-- name: One :one\nSELECT 1;\n-- name: One :one\nSELECT 1;",
+			wantErr: "users.sql:3: duplicate query One",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Act
+			_, err := parseQueries("users.sql", tt.src)
+
+			// Assert
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
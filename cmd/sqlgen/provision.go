@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+// provision returns the connection to an empty database, which is dropped by
+// the cleanup of t.
+func provision(ctx context.Context, t *generatorT) (conn *pgx.Conn, err error) {
+	err = t.do(func() {
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithReferenceDatabase("template0"))
+
+		conn, err = postgres.Conn(ctx)
+		if err != nil {
+			return
+		}
+
+		// The connection is closed before the database is dropped, because
+		// cleanups are run in the reverse order.
+		t.Cleanup(func() { _ = conn.Close(context.Background()) })
+	})
+
+	return conn, err
+}
+
+// errFailNow stops the function run by generatorT.do when it fails.
+var errFailNow = errors.New("failed")
+
+// generatorT lets the generator provision the database by testingpg, which is
+// made for tests. Failures are returned as errors by do, the cleanups are run
+// by cleanup when the generator is completed.
+type generatorT struct {
+	verbose bool
+
+	mu       sync.Mutex
+	errs     []error
+	cleanups []func()
+}
+
+var _ testingpg.TestingT = (*generatorT)(nil)
+
+func (t *generatorT) Errorf(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.errs = append(t.errs, fmt.Errorf(format, args...))
+}
+
+func (t *generatorT) FailNow() {
+	panic(errFailNow)
+}
+
+func (t *generatorT) Cleanup(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cleanups = append(t.cleanups, f)
+}
+
+func (t *generatorT) Log(args ...any) {
+	if t.verbose {
+		fmt.Fprintln(os.Stderr, args...)
+	}
+}
+
+func (t *generatorT) Logf(format string, args ...any) {
+	if t.verbose {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+}
+
+func (t *generatorT) Name() string {
+	return "sqlgen"
+}
+
+func (t *generatorT) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.errs) > 0
+}
+
+// do runs fn and returns the errors reported while it was running.
+func (t *generatorT) do(fn func()) (err error) {
+	t.mu.Lock()
+	reported := len(t.errs)
+	t.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil && r != errFailNow {
+			panic(r)
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		err = errors.Join(t.errs[reported:]...)
+	}()
+
+	fn()
+
+	return nil
+}
+
+// cleanup runs the cleanups in the reverse order, like testing.T does, and
+// returns the errors reported by them.
+func (t *generatorT) cleanup() error {
+	t.mu.Lock()
+	cleanups := t.cleanups
+	t.cleanups = nil
+	t.mu.Unlock()
+
+	errs := make([]error, 0)
+
+	for _, f := range slices.Backward(cleanups) {
+		errs = append(errs, t.do(f))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// initialisms are written in upper case in names of Go, like UserID.
+var initialisms = []string{"api", "http", "id", "json", "sql", "url", "uuid"}
+
+// exportedName returns the exported name of Go of the name of the column,
+// like UserID for user_id.
+func exportedName(column string) string {
+	output := strings.Builder{}
+
+	for word := range strings.FieldsFuncSeq(column, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		word = strings.ToLower(word)
+
+		if slices.Contains(initialisms, word) {
+			output.WriteString(strings.ToUpper(word))
+			continue
+		}
+
+		runes := []rune(word)
+		output.WriteString(string(unicode.ToUpper(runes[0])) + string(runes[1:]))
+	}
+
+	name := output.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "Column" + name
+	}
+
+	return name
+}
+
+// unexportedName returns the name with the lower case first letter, like
+// readUser for ReadUser.
+func unexportedName(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+
+	return string(runes)
+}
+
+var funcs = template.FuncMap{
+	"unexported": unexportedName,
+	"quote": func(sql string) string {
+		if strings.Contains(sql, "`") {
+			return strconv.Quote(sql)
+		}
+
+		return "`" + sql + "`"
+	},
+	"args": func(params []renderedField) string {
+		args := make([]string, 0, len(params))
+		for _, p := range params {
+			args = append(args, ", "+p.Name)
+		}
+
+		return strings.Join(args, "")
+	},
+	"params": func(params []renderedField) string {
+		args := make([]string, 0, len(params))
+		for _, p := range params {
+			args = append(args, fmt.Sprintf(", %s %s", p.Name, p.GoType))
+		}
+
+		return strings.Join(args, "")
+	},
+	"scan": func(columns []renderedField) string {
+		dest := make([]string, 0, len(columns))
+		for _, c := range columns {
+			dest = append(dest, "&item."+c.Name)
+		}
+
+		return strings.Join(dest, ", ")
+	},
+}
+
+var source = template.Must(template.New("source").Funcs(funcs).Parse(`
+// Code generated by sqlgen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"context"
+	"database/sql"
+{{- range .StdImports }}
+	{{ printf "%q" . }}
+{{- end }}
+{{- if .Imports }}
+{{ range .Imports }}
+	{{ printf "%q" . }}
+{{- end }}
+{{- end }}
+)
+
+// {{ unexported .Type }}DB is implemented by *sql.DB, *sql.Tx and DB of the repository.
+type {{ unexported .Type }}DB interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type {{ .Type }} struct {
+	db {{ unexported .Type }}DB
+}
+
+func New{{ .Type }}(db {{ unexported .Type }}DB) *{{ .Type }} {
+	return &{{ .Type }}{db: db}
+}
+{{ range .Queries }}
+{{- $type := $.Type }}
+const {{ unexported .Name }}SQL = {{ quote .SQL }}
+{{ if .Columns }}
+type {{ .Name }}Row struct {
+{{- range .Columns }}
+	{{ .Name }} {{ .GoType }}
+{{- end }}
+}
+{{ end }}
+{{- range .Doc }}
+// {{ . }}
+{{- end }}
+{{- if eq .Kind ":one" }}
+func (q *{{ $type }}) {{ .Name }}(ctx context.Context{{ params .Params }}) ({{ .Name }}Row, error) {
+	var item {{ .Name }}Row
+
+	err := q.db.QueryRowContext(ctx, {{ unexported .Name }}SQL{{ args .Params }}).
+		Scan({{ scan .Columns }})
+
+	return item, err
+}
+{{- else if eq .Kind ":many" }}
+{{- $result := printf "[]%sRow" .Name }}
+func (q *{{ $type }}) {{ .Name }}(ctx context.Context{{ params .Params }}) ({{ $result }}, error) {
+	rows, err := q.db.QueryContext(ctx, {{ unexported .Name }}SQL{{ args .Params }})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := make([]{{ .Name }}Row, 0)
+
+	for rows.Next() {
+		var item {{ .Name }}Row
+
+		if err := rows.Scan({{ scan .Columns }}); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+{{- else if eq .Kind ":exec" }}
+func (q *{{ $type }}) {{ .Name }}(ctx context.Context{{ params .Params }}) error {
+	_, err := q.db.ExecContext(ctx, {{ unexported .Name }}SQL{{ args .Params }})
+
+	return err
+}
+{{- else if eq .Kind ":execrows" }}
+func (q *{{ $type }}) {{ .Name }}(ctx context.Context{{ params .Params }}) (int64, error) {
+	result, err := q.db.ExecContext(ctx, {{ unexported .Name }}SQL{{ args .Params }})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+{{- end }}
+{{ end }}`))
+
+// renderedQuery is the describedQuery with the fields used by the template.
+type renderedQuery struct {
+	Name    string
+	Kind    string
+	Doc     []string
+	SQL     string
+	Params  []renderedField
+	Columns []renderedField
+}
+
+type renderedField struct {
+	Name   string
+	GoType string
+}
+
+// render returns the formatted source of the methods of typeName of the
+// queries in the package pkg.
+func render(pkg, typeName string, queries []describedQuery) ([]byte, error) {
+	imports := make([]string, 0)
+	rendered := make([]renderedQuery, 0, len(queries))
+
+	toRendered := func(fields []field) []renderedField {
+		out := make([]renderedField, 0, len(fields))
+
+		for _, f := range fields {
+			switch strings.TrimPrefix(f.goType, "*") {
+			case "time.Time":
+				imports = append(imports, "time")
+			case "uuid.UUID":
+				imports = append(imports, "github.com/google/uuid")
+			}
+
+			out = append(out, renderedField{Name: f.name, GoType: f.goType})
+		}
+
+		return out
+	}
+
+	for _, q := range queries {
+		rendered = append(rendered, renderedQuery{
+			Name:    q.name,
+			Kind:    q.kind,
+			Doc:     q.doc,
+			SQL:     q.sql,
+			Params:  toRendered(q.params),
+			Columns: toRendered(q.columns),
+		})
+	}
+
+	slices.Sort(imports)
+	imports = slices.Compact(imports)
+
+	// Imports of the standard library are separated from others, like
+	// goimports does.
+	std := slices.DeleteFunc(slices.Clone(imports), func(path string) bool {
+		return strings.Contains(path, ".")
+	})
+	other := slices.DeleteFunc(imports, func(path string) bool {
+		return !strings.Contains(path, ".")
+	})
+
+	data := map[string]any{
+		"Package":    pkg,
+		"Type":       typeName,
+		"StdImports": std,
+		"Imports":    other,
+		"Queries":    rendered,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := source.Execute(buf, data); err != nil {
+		return nil, err
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format the generated source: %w", err)
+	}
+
+	return src, nil
+}
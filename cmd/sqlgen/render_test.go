@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exportedName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"user_id":    "UserID",
+		"username":   "Username",
+		"created_at": "CreatedAt",
+		"?column?":   "Column",
+		"2fa":        "Column2fa",
+		"json_url":   "JSONURL",
+	}
+
+	for column, want := range tests {
+		t.Run(column, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, want, exportedName(column))
+		})
+	}
+}
+
+func Test_render(t *testing.T) {
+	t.Parallel()
+
+	readUser := describedQuery{
+		query: query{
+			name:   "ReadUser",
+			kind:   kindOne,
+			params: []string{"userID"},
+			doc:    []string{"ReadUser returns the user."},
+			sql:    "SELECT user_id, deleted_at FROM users WHERE user_id = $1;",
+		},
+		params: []field{{name: "userID", goType: "uuid.UUID"}},
+		columns: []field{
+			{name: "UserID", goType: "uuid.UUID"},
+			{name: "DeletedAt", goType: "*time.Time"},
+		},
+	}
+
+	listNames := describedQuery{
+		query:   query{name: "ListNames", kind: kindMany, sql: "SELECT '`' || username FROM users;"},
+		columns: []field{{name: "Column", goType: "*string"}},
+	}
+
+	purge := describedQuery{
+		query:  query{name: "Purge", kind: kindExecRows, sql: "DELETE FROM users WHERE version < $1;"},
+		params: []field{{name: "arg1", goType: "int64"}},
+	}
+
+	t.Run("Methods of all kinds are generated", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		src, err := render("users", "Queries", []describedQuery{readUser, listNames, purge})
+
+		// Assert
+		require.NoError(t, err)
+
+		file, err := parser.ParseFile(token.NewFileSet(), "queries.sql.go", src, parser.ParseComments)
+		require.NoError(t, err)
+
+		require.Equal(t, "users", file.Name.Name)
+
+		imports := make([]string, 0)
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			require.NoError(t, err)
+
+			imports = append(imports, path)
+		}
+
+		require.Equal(t, []string{"context", "database/sql", "time", "github.com/google/uuid"}, imports)
+
+		methods := make([]string, 0)
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				methods = append(methods, fn.Name.Name)
+			}
+		}
+
+		require.Equal(t, []string{"NewQueries", "ReadUser", "ListNames", "Purge"}, methods)
+		require.Contains(t, string(src), "// ReadUser returns the user.\nfunc (q *Queries) ReadUser(")
+		require.Contains(t, string(src), "ctx context.Context, userID uuid.UUID) (ReadUserRow, error)")
+		require.Contains(t, string(src), "\tDeletedAt *time.Time\n")
+		require.Contains(t, string(src), "const listNamesSQL = \"SELECT '`' || username FROM users;\"")
+		require.Contains(t, string(src), "Purge(ctx context.Context, arg1 int64) (int64, error)")
+	})
+
+	t.Run("Only used packages are imported", func(t *testing.T) {
+		t.Parallel()
+
+		// Act
+		src, err := render("users", "Queries", []describedQuery{purge})
+
+		// Assert
+		require.NoError(t, err)
+
+		file, err := parser.ParseFile(token.NewFileSet(), "queries.sql.go", src, parser.ImportsOnly)
+		require.NoError(t, err)
+		require.Len(t, file.Imports, 2)
+	})
+}
@@ -0,0 +1,29 @@
+-- Queries of the users table of the migrations of the repository.
+
+-- name: ReadUser :one
+-- param: userID
+-- ReadUser returns the user by its ID, including soft-deleted users.
+SELECT user_id, username, created_at, version, deleted_at
+FROM users
+WHERE user_id = $1;
+
+-- name: ListUsers :many
+-- param: afterID
+-- param: size
+SELECT user_id, username, created_at, version, deleted_at
+FROM users
+WHERE deleted_at IS NULL AND user_id > $1
+ORDER BY user_id
+LIMIT $2;
+
+-- name: CountUsers :one
+SELECT count(*) AS total FROM users;
+
+-- name: CreateUser :exec
+-- param: userID
+-- param: username
+-- param: createdAt
+INSERT INTO users (user_id, username, created_at) VALUES ($1, $2, $3);
+
+-- name: PurgeDeletedUsers :execrows
+DELETE FROM users WHERE deleted_at < $1;
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+// decorator is implemented by decorators of databases, like InstrumentDB and
+// NewLoggingDB.
+type decorator interface {
+	DB
+	caller
+	txWrapper
+}
+
+// caller is implemented by instrumented databases and their decorators,
+// UserRepository starts the span of every call by it.
+type caller interface {
+	startCall(ctx context.Context, method string) (context.Context, func(err error))
+}
+
+// txWrapper is implemented by decorators of databases, TxManager wraps the
+// transactions it starts by it.
+type txWrapper interface {
+	wrapTx(tx *sql.Tx) DB
+}
+
+// decorate returns d, which starts transactions only if db, the database
+// decorated by d, does. So the handle of testingpg.NewWithTransactionalCleanup
+// stays a handle which TxManager uses with savepoints.
+func decorate(d decorator, db DB) DB {
+	if b, ok := db.(beginner); ok {
+		return &decoratedBeginner{decorator: d, beginner: b}
+	}
+
+	return d
+}
+
+type decoratedBeginner struct {
+	decorator
+
+	beginner beginner
+}
+
+func (d *decoratedBeginner) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return d.beginner.BeginTx(ctx, opts)
+}
+
+// wrapTx wraps the transaction started on db by the decorators of db.
+func wrapTx(db DB, tx *sql.Tx) DB {
+	if wrapper, ok := db.(txWrapper); ok {
+		return wrapper.wrapTx(tx)
+	}
+
+	return tx
+}
@@ -0,0 +1,366 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attribute is a key-value pair describing a span or a measurement, keys
+// follow the OpenTelemetry semantic conventions where they exist.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Tracer starts spans, it is implemented by adapters of tracing libraries,
+// for example of OpenTelemetry, so that the package does not depend on them.
+type Tracer interface {
+	// Start starts the span, the returned context carries the span, so that
+	// spans started with it are its children.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is started by Tracer and must be ended by End.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Meter records latencies to histograms, it is implemented by adapters of
+// metrics libraries.
+type Meter interface {
+	RecordDuration(ctx context.Context, name string, duration time.Duration, attrs ...Attribute)
+}
+
+// Names of the histograms recorded by InstrumentDB.
+const (
+	// StatementDurationHistogram is the latency of a statement, with the
+	// db.operation attribute.
+	StatementDurationHistogram = "db.client.operation.duration"
+	// CallDurationHistogram is the latency of a method of UserRepository,
+	// including all its statements, with the method attribute.
+	CallDurationHistogram = "user_repository.call.duration"
+)
+
+type InstrumentOption func(o *instrumentOptions)
+
+type instrumentOptions struct {
+	tracer Tracer
+	meter  Meter
+}
+
+// WithTracer sets the tracer of the spans, by default spans are not started.
+func WithTracer(tracer Tracer) InstrumentOption {
+	return func(o *instrumentOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithMeter sets the meter of the histograms, by default latencies are not
+// recorded.
+func WithMeter(meter Meter) InstrumentOption {
+	return func(o *instrumentOptions) {
+		o.meter = meter
+	}
+}
+
+// InstrumentDB returns db which starts a span and records the latency of
+// every statement. The span has the db.statement and db.operation attributes
+// and the error of the statement. Only spans of ExecContext have the
+// db.rows_affected attribute: the span of QueryContext and QueryRowContext is
+// ended when the statement returns, before its rows are read, so neither the
+// number of the rows nor errors of Scan, like sql.ErrNoRows, are known to it.
+// UserRepository on top of the returned db also starts a span for every call,
+// which is the parent of the spans of its statements and records the error
+// returned by the call, including errors of reading the rows. Spans of calls
+// which read users have the db.rows_returned attribute, the number of rows
+// read from the database.
+//
+// The returned db starts transactions only if db does, so the handle of
+// testingpg.NewWithTransactionalCleanup stays a handle which TxManager uses
+// with savepoints. Statements of transactions started by TxManager are
+// instrumented too.
+func InstrumentDB(db DB, opts ...InstrumentOption) DB {
+	options := instrumentOptions{
+		tracer: noopTracer{},
+		meter:  noopMeter{},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return decorate(&instrumentedDB{db: db, options: options}, db)
+}
+
+type instrumentedDB struct {
+	db      DB
+	options instrumentOptions
+}
+
+func (i *instrumentedDB) QueryContext(
+	ctx context.Context,
+	query string,
+	args ...any,
+) (*sql.Rows, error) {
+	ctx, end := i.startStatement(ctx, query)
+
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	end(err)
+
+	return rows, err
+}
+
+// QueryRowContext records only errors which the row returns before Scan,
+// errors of Scan are recorded by the span of the call.
+func (i *instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, end := i.startStatement(ctx, query)
+
+	row := i.db.QueryRowContext(ctx, query, args...)
+	end(row.Err())
+
+	return row
+}
+
+func (i *instrumentedDB) ExecContext(
+	ctx context.Context,
+	query string,
+	args ...any,
+) (sql.Result, error) {
+	ctx, end := i.startStatement(ctx, query)
+
+	result, err := i.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		end(err)
+		return result, err
+	}
+
+	// The number of rows is not known for some statements, like SAVEPOINT.
+	affected, _ := result.RowsAffected()
+	end(nil, Attribute{Key: "db.rows_affected", Value: affected})
+
+	return result, nil
+}
+
+// startStatement starts the span of the statement, the returned function ends
+// it and records the latency.
+func (i *instrumentedDB) startStatement(
+	ctx context.Context,
+	query string,
+) (context.Context, func(err error, attrs ...Attribute)) {
+	operation := statementOperation(query)
+
+	ctx, span := i.options.tracer.Start(
+		ctx,
+		operation,
+		Attribute{Key: "db.system", Value: "postgresql"},
+		Attribute{Key: "db.statement", Value: query},
+		Attribute{Key: "db.operation", Value: operation},
+	)
+
+	started := time.Now()
+
+	return ctx, func(err error, attrs ...Attribute) {
+		defer span.End()
+
+		span.SetAttributes(attrs...)
+
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		i.options.meter.RecordDuration(
+			ctx,
+			StatementDurationHistogram,
+			time.Since(started),
+			Attribute{Key: "db.operation", Value: operation},
+		)
+	}
+}
+
+// startCall starts the span of the call of the method of UserRepository, the
+// returned function ends it and records the latency.
+func (i *instrumentedDB) startCall(
+	ctx context.Context,
+	method string,
+) (context.Context, func(err error)) {
+	ctx, span := i.options.tracer.Start(
+		ctx,
+		"UserRepository."+method,
+		Attribute{Key: "method", Value: method},
+	)
+	ctx = context.WithValue(ctx, callSpanKey{}, span)
+
+	started := time.Now()
+
+	return ctx, func(err error) {
+		defer span.End()
+
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		i.options.meter.RecordDuration(
+			ctx,
+			CallDurationHistogram,
+			time.Since(started),
+			Attribute{Key: "method", Value: method},
+		)
+	}
+}
+
+// wrapTx returns the transaction started by TxManager instrumented as db.
+func (i *instrumentedDB) wrapTx(tx *sql.Tx) DB {
+	return &instrumentedDB{db: wrapTx(i.db, tx), options: i.options}
+}
+
+type callSpanKey struct{}
+
+// recordReturnedRows sets the number of rows read from the database to the
+// span of the call of UserRepository, if the call is traced.
+func recordReturnedRows(ctx context.Context, rows int) {
+	if span, ok := ctx.Value(callSpanKey{}).(Span); ok {
+		span.SetAttributes(Attribute{Key: "db.rows_returned", Value: int64(rows)})
+	}
+}
+
+// statementOperation returns the first keyword of the statement, like SELECT.
+func statementOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(strings.TrimSuffix(fields[0], ";"))
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+type noopMeter struct{}
+
+func (noopMeter) RecordDuration(context.Context, string, time.Duration, ...Attribute) {}
+
+// RecordedSpan is a span ended in MemoryExporter.
+type RecordedSpan struct {
+	Name string
+	// Parent is the name of the parent span, it is empty for root spans.
+	Parent     string
+	Attributes map[string]any
+	Err        error
+}
+
+// Measurement is a latency recorded in MemoryExporter.
+type Measurement struct {
+	Name       string
+	Duration   time.Duration
+	Attributes map[string]any
+}
+
+// MemoryExporter is a Tracer and a Meter which keep spans and measurements in
+// memory, it is useful in tests.
+type MemoryExporter struct {
+	mu           sync.Mutex
+	spans        []RecordedSpan
+	measurements []Measurement
+}
+
+type memorySpanKey struct{}
+
+func (e *MemoryExporter) Start(
+	ctx context.Context,
+	name string,
+	attrs ...Attribute,
+) (context.Context, Span) {
+	span := &memorySpan{exporter: e, span: RecordedSpan{Name: name}}
+	span.SetAttributes(attrs...)
+
+	if parent, ok := ctx.Value(memorySpanKey{}).(*memorySpan); ok {
+		span.span.Parent = parent.span.Name
+	}
+
+	return context.WithValue(ctx, memorySpanKey{}, span), span
+}
+
+func (e *MemoryExporter) RecordDuration(
+	_ context.Context,
+	name string,
+	duration time.Duration,
+	attrs ...Attribute,
+) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.measurements = append(e.measurements, Measurement{
+		Name:       name,
+		Duration:   duration,
+		Attributes: attributesMap(attrs),
+	})
+}
+
+// Spans returns the ended spans in the order they were ended.
+func (e *MemoryExporter) Spans() []RecordedSpan {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]RecordedSpan(nil), e.spans...)
+}
+
+// Measurements returns the measurements in the order they were recorded.
+func (e *MemoryExporter) Measurements() []Measurement {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]Measurement(nil), e.measurements...)
+}
+
+type memorySpan struct {
+	exporter *MemoryExporter
+	span     RecordedSpan
+}
+
+func (s *memorySpan) SetAttributes(attrs ...Attribute) {
+	if s.span.Attributes == nil {
+		s.span.Attributes = make(map[string]any, len(attrs))
+	}
+
+	for _, attr := range attrs {
+		s.span.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *memorySpan) RecordError(err error) {
+	s.span.Err = err
+}
+
+func (s *memorySpan) End() {
+	s.exporter.mu.Lock()
+	defer s.exporter.mu.Unlock()
+
+	s.exporter.spans = append(s.exporter.spans, s.span)
+}
+
+func attributesMap(attrs []Attribute) map[string]any {
+	m := make(map[string]any, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Key] = attr.Value
+	}
+
+	return m
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+// findSpan returns the first span with the name.
+func findSpan(t *testing.T, spans []rootpkg.RecordedSpan, name string) rootpkg.RecordedSpan {
+	t.Helper()
+
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	require.Failf(t, "span is not found", "name %q", name)
+
+	return rootpkg.RecordedSpan{}
+}
+
+func TestInstrumentDB(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Error of the statement is recorded", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		exporter := &rootpkg.MemoryExporter{}
+		errTest := errors.New("test error")
+
+		db := rootpkg.InstrumentDB(
+			execErrorDB{err: errTest},
+			rootpkg.WithTracer(exporter),
+			rootpkg.WithMeter(exporter),
+		)
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		err := repo.CreateUser(tenantContext(), rootpkg.User{ID: uuid.New()})
+
+		// Assert
+		require.ErrorIs(t, err, errTest)
+
+		spans := exporter.Spans()
+
+		insert := findSpan(t, spans, "INSERT")
+		require.ErrorIs(t, insert.Err, errTest)
+		require.Equal(t, "UserRepository.CreateUser", insert.Parent)
+		require.Equal(t, "INSERT", insert.Attributes["db.operation"])
+		require.Contains(t, insert.Attributes["db.statement"], "INSERT INTO users")
+
+		call := findSpan(t, spans, "UserRepository.CreateUser")
+		require.ErrorIs(t, call.Err, errTest)
+		require.Empty(t, call.Parent)
+	})
+
+	t.Run("Latency of the call is recorded per method", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		exporter := &rootpkg.MemoryExporter{}
+		repo := rootpkg.NewUserRepository(rootpkg.InstrumentDB(
+			execErrorDB{},
+			rootpkg.WithMeter(exporter),
+		))
+
+		// Act
+		err := repo.DeleteUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		methods := make([]any, 0)
+
+		for _, measurement := range exporter.Measurements() {
+			if measurement.Name == rootpkg.CallDurationHistogram {
+				methods = append(methods, measurement.Attributes["method"])
+			}
+		}
+
+		require.Equal(t, []any{"DeleteUser"}, methods)
+	})
+}
+
+func TestInstrumentDB_Statements(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Statements of the transaction are children of the call", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		exporter := &rootpkg.MemoryExporter{}
+		repo := rootpkg.NewUserRepository(rootpkg.InstrumentDB(
+			postgres.DB(),
+			rootpkg.WithTracer(exporter),
+			rootpkg.WithMeter(exporter),
+		))
+
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", CreatedAt: time.Now()}
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		spans := exporter.Spans()
+
+		insert := findSpan(t, spans, "INSERT")
+		require.NoError(t, insert.Err)
+		require.Equal(t, "UserRepository.CreateUser", insert.Parent)
+		require.Equal(t, int64(1), insert.Attributes["db.rows_affected"])
+
+		call := findSpan(t, spans, "UserRepository.CreateUser")
+		require.NoError(t, call.Err)
+
+		// The tenant is not reset in the transaction started by the call.
+		scopes := slices.DeleteFunc(spans, func(span rootpkg.RecordedSpan) bool {
+			statement, _ := span.Attributes["db.statement"].(string)
+			return !strings.Contains(statement, "set_config")
+		})
+		require.Len(t, scopes, 1)
+	})
+
+	t.Run("Error of the call is recorded", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		exporter := &rootpkg.MemoryExporter{}
+		repo := rootpkg.NewUserRepository(rootpkg.InstrumentDB(
+			postgres.DB(),
+			rootpkg.WithTracer(exporter),
+		))
+
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", CreatedAt: time.Now()}
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserIDConflict)
+
+		spans := exporter.Spans()
+
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, spans[len(spans)-1].Err, &pgErr)
+		require.Equal(t, "UserRepository.CreateUser", spans[len(spans)-1].Name)
+	})
+
+	t.Run("Error of Scan is recorded only by the call", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		exporter := &rootpkg.MemoryExporter{}
+		repo := rootpkg.NewUserRepository(rootpkg.InstrumentDB(
+			postgres.DB(),
+			rootpkg.WithTracer(exporter),
+		))
+
+		// Act
+		_, err := repo.ReadUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		spans := exporter.Spans()
+
+		i := slices.IndexFunc(spans, func(span rootpkg.RecordedSpan) bool {
+			statement, _ := span.Attributes["db.statement"].(string)
+			return strings.HasPrefix(statement, "SELECT user_id")
+		})
+		require.GreaterOrEqual(t, i, 0)
+		require.NoError(t, spans[i].Err)
+		require.NotContains(t, spans[i].Attributes, "db.rows_affected")
+
+		call := findSpan(t, spans, "UserRepository.ReadUser")
+		require.ErrorIs(t, call.Err, rootpkg.ErrUserNotFound)
+		require.Equal(t, int64(0), call.Attributes["db.rows_returned"])
+	})
+
+	t.Run("Rows returned by reads are recorded by the call", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		exporter := &rootpkg.MemoryExporter{}
+		repo := rootpkg.NewUserRepository(rootpkg.InstrumentDB(
+			postgres.DB(),
+			rootpkg.WithTracer(exporter),
+		))
+
+		user := newFullyFiledUser()
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.CreateUser(tenantContext(), newFullyFiledUser()))
+
+		// Act
+		_, readErr := repo.ReadUser(tenantContext(), user.ID)
+		_, _, listErr := repo.ListUsers(tenantContext(), rootpkg.UserFilter{}, rootpkg.Page{})
+		_, historyErr := repo.ReadUserHistory(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, readErr)
+		require.NoError(t, listErr)
+		require.NoError(t, historyErr)
+
+		spans := exporter.Spans()
+
+		read := findSpan(t, spans, "UserRepository.ReadUser")
+		require.Equal(t, int64(1), read.Attributes["db.rows_returned"])
+
+		list := findSpan(t, spans, "UserRepository.ListUsers")
+		require.Equal(t, int64(2), list.Attributes["db.rows_returned"])
+
+		history := findSpan(t, spans, "UserRepository.ReadUserHistory")
+		require.Equal(t, int64(1), history.Attributes["db.rows_returned"])
+	})
+}
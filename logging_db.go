@@ -0,0 +1,241 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LoggingOption func(o *loggingOptions)
+
+type loggingOptions struct {
+	level           slog.Level
+	redactedColumns []string
+	// redactedRe matches statements which mention the redacted columns.
+	redactedRe *regexp.Regexp
+}
+
+// WithLogLevel sets the level of records of successful statements, by default
+// it is slog.LevelDebug. Failed statements are logged with slog.LevelError.
+func WithLogLevel(level slog.Level) LoggingOption {
+	return func(o *loggingOptions) {
+		o.level = level
+	}
+}
+
+// WithRedactedColumns replaces the arguments bound to any of the columns with
+// RedactedValue in the log, other arguments, like IDs and versions, are logged
+// as is. An argument is bound to a column if it is inserted to the column, or
+// if its placeholder is in the same condition, assignment or item of a list as
+// the column, so in `lower(username) = lower($1) AND $2` only $1 is redacted.
+// Arguments bound to the column through aliases, for example of a CTE, are not
+// recognized and are logged as is.
+func WithRedactedColumns(columns ...string) LoggingOption {
+	return func(o *loggingOptions) {
+		for _, column := range columns {
+			o.redactedColumns = append(o.redactedColumns, strings.ToLower(column))
+		}
+	}
+}
+
+// RedactedValue replaces arguments of columns set by WithRedactedColumns.
+const RedactedValue = "[REDACTED]"
+
+// NewLoggingDB returns db which logs every statement to logger with its
+// arguments, duration and error. Like InstrumentDB, the returned db starts
+// transactions only if db does, so it can wrap both *sql.DB and the handle of
+// testingpg.NewWithTransactionalCleanup, and statements of transactions
+// started by TxManager are logged too.
+func NewLoggingDB(db DB, logger *slog.Logger, opts ...LoggingOption) DB {
+	options := loggingOptions{level: slog.LevelDebug}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(options.redactedColumns) > 0 {
+		quoted := make([]string, 0, len(options.redactedColumns))
+		for _, column := range options.redactedColumns {
+			quoted = append(quoted, regexp.QuoteMeta(column))
+		}
+
+		options.redactedRe = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+
+	return decorate(&LoggingDB{db: db, logger: logger, options: options}, db)
+}
+
+// LoggingDB is the DB returned by NewLoggingDB for databases which cannot
+// start transactions.
+type LoggingDB struct {
+	db      DB
+	logger  *slog.Logger
+	options loggingOptions
+}
+
+func (l *LoggingDB) QueryContext(
+	ctx context.Context,
+	query string,
+	args ...any,
+) (*sql.Rows, error) {
+	started := time.Now()
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	l.log(ctx, query, args, started, err)
+
+	return rows, err
+}
+
+// QueryRowContext logs only errors which the row returns before Scan.
+func (l *LoggingDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	started := time.Now()
+
+	row := l.db.QueryRowContext(ctx, query, args...)
+	l.log(ctx, query, args, started, row.Err())
+
+	return row
+}
+
+func (l *LoggingDB) ExecContext(
+	ctx context.Context,
+	query string,
+	args ...any,
+) (sql.Result, error) {
+	started := time.Now()
+
+	result, err := l.db.ExecContext(ctx, query, args...)
+	l.log(ctx, query, args, started, err)
+
+	return result, err
+}
+
+func (l *LoggingDB) log(
+	ctx context.Context,
+	query string,
+	args []any,
+	started time.Time,
+	err error,
+) {
+	level := l.options.level
+	if err != nil {
+		level = slog.LevelError
+	}
+
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("sql", query),
+		slog.Any("args", l.redact(query, args)),
+		slog.Duration("duration", time.Since(started)),
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+
+	l.logger.LogAttrs(ctx, level, "statement", attrs...)
+}
+
+var (
+	// insertColumnsRe matches the column list of INSERT and the rest of the
+	// statement, whose placeholders are bound to the columns in order.
+	insertColumnsRe = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+[^(]+\(([^)]*)\)(.*)$`)
+	// stringLiteralRe matches string literals, which may contain keywords.
+	stringLiteralRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// betweenRe matches the bounds of BETWEEN, whose AND joins no conditions.
+	betweenRe = regexp.MustCompile(`(?is)\bBETWEEN\b(.*?)\bAND\b`)
+	// clauseRe matches keywords which separate conditions and clauses.
+	clauseRe = regexp.MustCompile(
+		`(?i)\b(?:SELECT|WHERE|AND|OR|SET|ON|JOIN|VALUES|RETURNING|GROUP|ORDER|HAVING|LIMIT|OFFSET)\b`,
+	)
+	placeholderRe = regexp.MustCompile(`\$(\d+)`)
+)
+
+// redact returns args with RedactedValue instead of the arguments bound to the
+// redacted columns by the query.
+func (l *LoggingDB) redact(query string, args []any) []any {
+	re := l.options.redactedRe
+	if re == nil || !re.MatchString(query) {
+		return args
+	}
+
+	redacted := slices.Clone(args)
+	redactPlaceholders := func(s string) {
+		for _, match := range placeholderRe.FindAllStringSubmatch(s, -1) {
+			n, err := strconv.Atoi(match[1])
+			if err == nil && n >= 1 && n <= len(redacted) {
+				redacted[n-1] = RedactedValue
+			}
+		}
+	}
+
+	query = stringLiteralRe.ReplaceAllString(query, "''")
+
+	if match := insertColumnsRe.FindStringSubmatch(query); match != nil {
+		placeholders := placeholderRe.FindAllString(match[2], -1)
+		for i, column := range strings.Split(match[1], ",") {
+			if i < len(placeholders) && re.MatchString(column) {
+				redactPlaceholders(placeholders[i])
+			}
+		}
+	}
+
+	query = betweenRe.ReplaceAllString(query, "BETWEEN ${1}")
+	for _, clause := range clauseRe.Split(query, -1) {
+		for _, part := range splitList(clause) {
+			if re.MatchString(part) {
+				redactPlaceholders(part)
+			}
+		}
+	}
+
+	return redacted
+}
+
+// splitList splits s by the commas which are not enclosed in parentheses, so
+// the arguments of IN and of functions stay with their column.
+func splitList(s string) []string {
+	var parts []string
+
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth <= 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// startCall passes the call of UserRepository to the instrumented database
+// wrapped by l, so that the order of the decorators does not matter.
+func (l *LoggingDB) startCall(ctx context.Context, method string) (context.Context, func(error)) {
+	if c, ok := l.db.(caller); ok {
+		return c.startCall(ctx, method)
+	}
+
+	return ctx, func(error) {}
+}
+
+// wrapTx returns the transaction started by TxManager logged as db.
+func (l *LoggingDB) wrapTx(tx *sql.Tx) DB {
+	return &LoggingDB{db: wrapTx(l.db, tx), logger: l.logger, options: l.options}
+}
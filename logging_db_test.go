@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+// logRecord is a record of slog.JSONHandler.
+type logRecord struct {
+	Level string `json:"level"`
+	SQL   string `json:"sql"`
+	Args  []any  `json:"args"`
+	Error string `json:"error"`
+}
+
+// parseLogRecords returns the records of the statements on the users table.
+func parseLogRecords(t *testing.T, buf *bytes.Buffer) []logRecord {
+	t.Helper()
+
+	records := make([]logRecord, 0)
+
+	for line := range strings.Lines(buf.String()) {
+		record := logRecord{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+
+		if strings.Contains(record.SQL, "users") {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+func TestLoggingDB(t *testing.T) {
+	t.Parallel()
+
+	newLogger := func() (*slog.Logger, *bytes.Buffer) {
+		buf := &bytes.Buffer{}
+		handler := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+
+		return slog.New(handler), buf
+	}
+
+	t.Run("Arguments of redacted columns are replaced", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		logger, buf := newLogger()
+		db := rootpkg.NewLoggingDB(execErrorDB{}, logger, rootpkg.WithRedactedColumns("Username"))
+		repo := rootpkg.NewUserRepository(db)
+
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", CreatedAt: time.Now()}
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		records := parseLogRecords(t, buf)
+		require.Len(t, records, 1)
+		require.Equal(t, "DEBUG", records[0].Level)
+		require.Len(t, records[0].Args, 4)
+		require.Equal(t, user.ID.String(), records[0].Args[0])
+		require.Equal(t, rootpkg.RedactedValue, records[0].Args[1])
+	})
+
+	t.Run("Only arguments bound to redacted columns are replaced", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		logger, buf := newLogger()
+		errTest := errors.New("test error")
+
+		db := rootpkg.NewLoggingDB(
+			execErrorDB{err: errTest},
+			logger,
+			rootpkg.WithRedactedColumns("username"),
+		)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", Version: 7}
+
+		// Act
+		err := repo.UpdateUser(tenantContext(), user, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.ErrorIs(t, err, errTest)
+
+		records := parseLogRecords(t, buf)
+		require.Len(t, records, 1)
+		require.Equal(t, "ERROR", records[0].Level)
+		require.Equal(t, errTest.Error(), records[0].Error)
+		require.Equal(t, []any{user.ID.String(), 7.0, rootpkg.RedactedValue}, records[0].Args)
+	})
+
+	t.Run("Redaction does not depend on the form of the statement", func(t *testing.T) {
+		t.Parallel()
+
+		redacted := rootpkg.RedactedValue
+		tests := []struct {
+			query string
+			want  []any
+		}{
+			{
+				query: "SELECT user_id FROM users WHERE $1 = username AND version = $2;",
+				want:  []any{redacted, "rob"},
+			},
+			{
+				query: "SELECT user_id FROM users WHERE Username IN ($1, $2);",
+				want:  []any{redacted, redacted},
+			},
+			{
+				query: `SELECT user_id FROM users WHERE "username" = ANY($1) LIMIT $2;`,
+				want:  []any{redacted, "rob"},
+			},
+			{
+				query: "SELECT user_id FROM users WHERE username BETWEEN $1 AND $2;",
+				want:  []any{redacted, redacted},
+			},
+			{
+				query: "UPDATE users SET created_at = $1, username = coalesce($2, username);",
+				want:  []any{"gopher", redacted},
+			},
+			{
+				query: "SELECT user_id FROM users WHERE user_id = $1 OR 'username' = $2;",
+				want:  []any{"gopher", "rob"},
+			},
+		}
+
+		for _, tt := range tests {
+			// Arrange
+			logger, buf := newLogger()
+			db := rootpkg.NewLoggingDB(execErrorDB{}, logger, rootpkg.WithRedactedColumns("username"))
+
+			// Act
+			_, err := db.ExecContext(context.Background(), tt.query, "gopher", "rob")
+
+			// Assert
+			require.NoError(t, err)
+
+			records := parseLogRecords(t, buf)
+			require.Len(t, records, 1)
+			require.Equal(t, tt.want, records[0].Args, tt.query)
+		}
+	})
+
+	t.Run("Arguments are logged as is without redacted columns", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		logger, buf := newLogger()
+		repo := rootpkg.NewUserRepository(rootpkg.NewLoggingDB(execErrorDB{}, logger))
+
+		userID := uuid.New()
+
+		// Act
+		err := repo.SoftDeleteUser(tenantContext(), userID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		records := parseLogRecords(t, buf)
+		require.Len(t, records, 1)
+		require.Equal(t, []any{userID.String()}, records[0].Args)
+	})
+
+	t.Run("Records below the level are not logged", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		buf := &bytes.Buffer{}
+		logger := slog.New(slog.NewJSONHandler(buf, nil))
+		repo := rootpkg.NewUserRepository(rootpkg.NewLoggingDB(execErrorDB{}, logger))
+
+		// Act
+		err := repo.DeleteUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+		require.Empty(t, buf.String())
+	})
+}
+
+func TestLoggingDB_Statements(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Statements of the transaction are logged", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+
+		buf := &bytes.Buffer{}
+		handler := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+		db := rootpkg.NewLoggingDB(postgres.DB(), slog.New(handler))
+		repo := rootpkg.NewUserRepository(db)
+
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", CreatedAt: time.Now()}
+
+		// Act
+		err := rootpkg.NewTxManager(db).WithinTx(tenantContext(), func(ctx context.Context) error {
+			return repo.CreateUser(ctx, user)
+		})
+
+		// Assert
+		require.NoError(t, err)
+
+		records := parseLogRecords(t, buf)
+		require.Len(t, records, 1)
+		require.Contains(t, records[0].SQL, "INSERT INTO users")
+	})
+
+	t.Run("Decorators are composable", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		exporter := &rootpkg.MemoryExporter{}
+
+		db := rootpkg.InstrumentDB(
+			rootpkg.NewLoggingDB(postgres.DB(), testingpg.NewLogger(t)),
+			rootpkg.WithTracer(exporter),
+		)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", CreatedAt: time.Now()}
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		insert := findSpan(t, exporter.Spans(), "INSERT")
+		require.Equal(t, "UserRepository.CreateUser", insert.Parent)
+	})
+}
@@ -0,0 +1 @@
+REVOKE SELECT, INSERT ON users FROM app;
@@ -0,0 +1,12 @@
+-- The application role is shared by all databases of the cluster, so it may
+-- already exist, including when migrations are applied concurrently.
+DO
+$$
+    BEGIN
+        CREATE ROLE app NOLOGIN;
+    EXCEPTION
+        WHEN duplicate_object OR unique_violation THEN NULL;
+    END
+$$;
+
+GRANT SELECT, INSERT ON users TO app;
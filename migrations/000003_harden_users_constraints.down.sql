@@ -0,0 +1,7 @@
+DROP INDEX users_username_lower_key;
+
+ALTER TABLE users
+    ADD CONSTRAINT users_username_key UNIQUE (username),
+    DROP CONSTRAINT users_username_check,
+    DROP CONSTRAINT users_pkey,
+    ALTER COLUMN user_id DROP NOT NULL;
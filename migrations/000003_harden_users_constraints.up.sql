@@ -0,0 +1,10 @@
+ALTER TABLE users
+    ADD CONSTRAINT users_pkey PRIMARY KEY (user_id),
+    ADD CONSTRAINT users_username_check CHECK (
+        char_length(username) BETWEEN 3 AND 50 AND username ~ '^[A-Za-z0-9_.-]+$'
+    ),
+    DROP CONSTRAINT users_username_key;
+
+-- Usernames are unique regardless of case, the index also replaces the case
+-- sensitive unique constraint.
+CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username));
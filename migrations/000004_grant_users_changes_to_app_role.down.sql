@@ -0,0 +1 @@
+REVOKE UPDATE, DELETE ON users FROM app;
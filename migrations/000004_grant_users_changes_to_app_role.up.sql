@@ -0,0 +1 @@
+GRANT UPDATE, DELETE ON users TO app;
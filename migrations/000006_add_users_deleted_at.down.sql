@@ -0,0 +1,9 @@
+-- Soft-deleted users cannot be represented without the column, and their
+-- usernames may be taken again, so they are purged.
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX users_username_lower_key;
+CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username));
+
+ALTER TABLE users
+    DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN deleted_at timestamptz;
+
+-- Usernames of soft-deleted users can be reclaimed, so only usernames of
+-- active users are unique.
+DROP INDEX users_username_lower_key;
+CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username)) WHERE deleted_at IS NULL;
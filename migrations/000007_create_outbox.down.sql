@@ -0,0 +1,3 @@
+DROP TRIGGER users_outbox ON users;
+DROP FUNCTION users_outbox();
+DROP TABLE outbox;
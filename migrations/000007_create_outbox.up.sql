@@ -0,0 +1,49 @@
+CREATE TABLE outbox
+(
+    event_id     bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    event_type   text        NOT NULL,
+    user_id      uuid        NOT NULL,
+    payload      jsonb       NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    delivered_at timestamptz
+);
+
+-- The relay polls only undelivered events in the order of their IDs.
+CREATE INDEX outbox_undelivered_idx ON outbox (event_id) WHERE delivered_at IS NULL;
+
+-- Events are written by the trigger in the same transaction as the change of
+-- the user, so an event is never lost or published for a rolled back change.
+CREATE FUNCTION users_outbox() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+BEGIN
+    IF tg_op = 'INSERT' THEN
+        INSERT INTO outbox (event_type, user_id, payload)
+        VALUES ('UserCreated', new.user_id, to_jsonb(new));
+    ELSIF tg_op = 'UPDATE' AND old.deleted_at IS NULL AND new.deleted_at IS NOT NULL THEN
+        INSERT INTO outbox (event_type, user_id, payload)
+        VALUES ('UserDeleted', new.user_id, to_jsonb(new));
+    ELSIF tg_op = 'UPDATE' AND old.deleted_at IS NOT NULL AND new.deleted_at IS NULL THEN
+        INSERT INTO outbox (event_type, user_id, payload)
+        VALUES ('UserRestored', new.user_id, to_jsonb(new));
+    ELSIF tg_op = 'UPDATE' THEN
+        INSERT INTO outbox (event_type, user_id, payload)
+        VALUES ('UserUpdated', new.user_id, to_jsonb(new));
+    ELSIF tg_op = 'DELETE' AND old.deleted_at IS NULL THEN
+        -- Purge of a soft-deleted user is not an event, its deletion has
+        -- already been published.
+        INSERT INTO outbox (event_type, user_id, payload)
+        VALUES ('UserDeleted', old.user_id, to_jsonb(old));
+    END IF;
+
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER users_outbox
+    AFTER INSERT OR UPDATE OR DELETE
+    ON users
+    FOR EACH ROW
+EXECUTE FUNCTION users_outbox();
+
+GRANT SELECT, INSERT, UPDATE ON outbox TO app;
@@ -0,0 +1,2 @@
+DROP TRIGGER users_notify ON users;
+DROP FUNCTION users_notify();
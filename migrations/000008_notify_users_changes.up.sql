@@ -0,0 +1,29 @@
+-- Every change of a user is notified to the users_changes channel, the
+-- payload is kept small because it is limited to 8000 bytes.
+CREATE FUNCTION users_notify() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+DECLARE
+    changed users;
+BEGIN
+    IF tg_op = 'DELETE' THEN
+        changed := old;
+    ELSE
+        changed := new;
+    END IF;
+
+    PERFORM pg_notify('users_changes', json_build_object(
+            'op', tg_op,
+            'user_id', changed.user_id,
+            'version', changed.version
+        )::text);
+
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER users_notify
+    AFTER INSERT OR UPDATE OR DELETE
+    ON users
+    FOR EACH ROW
+EXECUTE FUNCTION users_notify();
@@ -0,0 +1,3 @@
+DROP TRIGGER users_audit ON users;
+DROP FUNCTION users_audit();
+DROP TABLE users_audit;
@@ -0,0 +1,42 @@
+CREATE TABLE users_audit
+(
+    audit_id   bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id    uuid        NOT NULL,
+    operation  text        NOT NULL,
+    old_row    jsonb,
+    new_row    jsonb,
+    actor      text,
+    changed_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX users_audit_user_id_idx ON users_audit (user_id, audit_id);
+
+-- The function is executed with privileges of its owner, so the application
+-- can read the history but cannot forge it. The search path is fixed to the
+-- one of the migration to prevent substitution of the audit table.
+CREATE FUNCTION users_audit() RETURNS trigger
+    LANGUAGE plpgsql
+    SECURITY DEFINER
+    SET search_path FROM CURRENT AS
+$$
+BEGIN
+    -- The actor is set by SET LOCAL app.actor, the empty value remains in
+    -- the session after the end of the transaction which set it.
+    INSERT INTO users_audit (user_id, operation, old_row, new_row, actor)
+    VALUES (coalesce(new.user_id, old.user_id),
+            tg_op,
+            to_jsonb(old),
+            to_jsonb(new),
+            nullif(current_setting('app.actor', true), ''));
+
+    RETURN NULL;
+END
+$$;
+
+CREATE TRIGGER users_audit
+    AFTER INSERT OR UPDATE OR DELETE
+    ON users
+    FOR EACH ROW
+EXECUTE FUNCTION users_audit();
+
+GRANT SELECT ON users_audit TO app;
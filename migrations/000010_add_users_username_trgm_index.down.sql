@@ -0,0 +1,2 @@
+-- The extension is kept, because it may be used by other schemas.
+DROP INDEX users_username_trgm_idx;
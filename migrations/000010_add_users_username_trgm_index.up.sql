@@ -0,0 +1,15 @@
+-- An extension is installed once for the whole database, so it is installed
+-- in the public schema, which is shared by all schemas, and may already exist,
+-- including when migrations are applied concurrently. Its objects are
+-- qualified by the schema, because the public schema may be not in the search
+-- path, like in isolated schemas of tests.
+DO
+$$
+    BEGIN
+        CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+    EXCEPTION
+        WHEN duplicate_object OR unique_violation THEN NULL;
+    END
+$$;
+
+CREATE INDEX users_username_trgm_idx ON users USING gin (lower(username) public.gin_trgm_ops);
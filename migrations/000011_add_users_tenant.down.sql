@@ -0,0 +1,47 @@
+CREATE OR REPLACE FUNCTION users_audit() RETURNS trigger
+    LANGUAGE plpgsql
+    SECURITY DEFINER
+    SET search_path FROM CURRENT AS
+$$
+BEGIN
+    -- The actor is set by SET LOCAL app.actor, the empty value remains in
+    -- the session after the end of the transaction which set it.
+    INSERT INTO users_audit (user_id, operation, old_row, new_row, actor)
+    VALUES (coalesce(new.user_id, old.user_id),
+            tg_op,
+            to_jsonb(old),
+            to_jsonb(new),
+            nullif(current_setting('app.actor', true), ''));
+
+    RETURN NULL;
+END
+$$;
+
+DROP POLICY users_audit_tenant_isolation ON users_audit;
+
+ALTER TABLE users_audit
+    DISABLE ROW LEVEL SECURITY;
+
+ALTER TABLE users_audit
+    DROP COLUMN tenant_id;
+
+DROP POLICY users_tenant_isolation ON users;
+
+ALTER TABLE users
+    DISABLE ROW LEVEL SECURITY;
+
+-- Usernames of different tenants may coincide, so only the earliest active
+-- user keeps the username and the others are soft-deleted.
+UPDATE users
+SET deleted_at = now()
+WHERE deleted_at IS NULL
+  AND user_id NOT IN (SELECT DISTINCT ON (lower(username)) user_id
+                      FROM users
+                      WHERE deleted_at IS NULL
+                      ORDER BY lower(username), created_at, user_id);
+
+DROP INDEX users_username_lower_key;
+CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username)) WHERE deleted_at IS NULL;
+
+ALTER TABLE users
+    DROP COLUMN tenant_id;
@@ -0,0 +1,58 @@
+-- Users created before tenants were introduced belong to the nil tenant.
+ALTER TABLE users
+    ADD COLUMN tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+
+-- New users belong to the tenant of the transaction, the insertion fails
+-- without the tenant because of NOT NULL. The empty value remains in the
+-- session after the end of the transaction which set app.tenant_id.
+ALTER TABLE users
+    ALTER COLUMN tenant_id SET DEFAULT nullif(current_setting('app.tenant_id', true), '')::uuid;
+
+-- Usernames are unique within the tenant.
+DROP INDEX users_username_lower_key;
+CREATE UNIQUE INDEX users_username_lower_key
+    ON users (tenant_id, lower(username)) WHERE deleted_at IS NULL;
+
+-- The policy applies to all roles except superusers, roles with BYPASSRLS
+-- and the owner of the table, which applies migrations. Without the tenant
+-- no rows are visible and no rows can be written.
+ALTER TABLE users
+    ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY users_tenant_isolation ON users
+    USING (tenant_id = nullif(current_setting('app.tenant_id', true), '')::uuid);
+
+ALTER TABLE users_audit
+    ADD COLUMN tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+
+ALTER TABLE users_audit
+    ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE users_audit
+    ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY users_audit_tenant_isolation ON users_audit
+    USING (tenant_id = nullif(current_setting('app.tenant_id', true), '')::uuid);
+
+-- The function is owned by the owner of the audit table, so the policy does
+-- not prevent the insertion. The outbox is not subject to the policies, it is
+-- read by the relay for all tenants, the tenant is a part of the payload.
+CREATE OR REPLACE FUNCTION users_audit() RETURNS trigger
+    LANGUAGE plpgsql
+    SECURITY DEFINER
+    SET search_path FROM CURRENT AS
+$$
+BEGIN
+    -- The actor is set by SET LOCAL app.actor, the empty value remains in
+    -- the session after the end of the transaction which set it.
+    INSERT INTO users_audit (user_id, tenant_id, operation, old_row, new_row, actor)
+    VALUES (coalesce(new.user_id, old.user_id),
+            coalesce(new.tenant_id, old.tenant_id),
+            tg_op,
+            to_jsonb(old),
+            to_jsonb(new),
+            nullif(current_setting('app.actor', true), ''));
+
+    RETURN NULL;
+END
+$$;
@@ -0,0 +1,21 @@
+CREATE OR REPLACE FUNCTION users_notify() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+DECLARE
+    changed users;
+BEGIN
+    IF tg_op = 'DELETE' THEN
+        changed := old;
+    ELSE
+        changed := new;
+    END IF;
+
+    PERFORM pg_notify('users_changes', json_build_object(
+            'op', tg_op,
+            'user_id', changed.user_id,
+            'version', changed.version
+        )::text);
+
+    RETURN NULL;
+END
+$$;
@@ -0,0 +1,24 @@
+-- Notifications are sent to all sessions of the database, so the tenant is a
+-- part of the payload and the watcher delivers only changes of its tenant.
+CREATE OR REPLACE FUNCTION users_notify() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+DECLARE
+    changed users;
+BEGIN
+    IF tg_op = 'DELETE' THEN
+        changed := old;
+    ELSE
+        changed := new;
+    END IF;
+
+    PERFORM pg_notify('users_changes', json_build_object(
+            'op', tg_op,
+            'user_id', changed.user_id,
+            'tenant_id', changed.tenant_id,
+            'version', changed.version
+        )::text);
+
+    RETURN NULL;
+END
+$$;
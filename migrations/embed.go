@@ -6,5 +6,5 @@ package migrations
 
 import "embed"
 
-//go:embed *.up.sql
+//go:embed *.sql
 var FS embed.FS
@@ -0,0 +1,246 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType is the type of the event of the lifecycle of a user.
+type EventType string
+
+const (
+	UserCreated  EventType = "UserCreated"
+	UserUpdated  EventType = "UserUpdated"
+	UserDeleted  EventType = "UserDeleted"
+	UserRestored EventType = "UserRestored"
+)
+
+// UserEvent is written to the outbox table in the same transaction as the
+// change of the user.
+type UserEvent struct {
+	ID     int64
+	Type   EventType
+	UserID uuid.UUID
+	// Payload is the JSON representation of the row of the user after the
+	// change, or before the change for UserDeleted of DeleteUser.
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// Publisher delivers events to downstream services.
+type Publisher interface {
+	Publish(ctx context.Context, event UserEvent) error
+}
+
+type RelayOption func(o *relayOptions)
+
+type relayOptions struct {
+	batchSize int
+	interval  time.Duration
+}
+
+// WithRelayBatchSize sets the maximum number of events published in one
+// transaction, by default it is 100.
+func WithRelayBatchSize(size int) RelayOption {
+	return func(o *relayOptions) {
+		o.batchSize = max(size, 1)
+	}
+}
+
+// WithRelayInterval sets the interval of polling of the outbox by Run when
+// there are no undelivered events, by default it is 1 second.
+func WithRelayInterval(interval time.Duration) RelayOption {
+	return func(o *relayOptions) {
+		o.interval = interval
+	}
+}
+
+const (
+	defaultRelayBatchSize = 100
+	defaultRelayInterval  = time.Second
+)
+
+// NewOutboxRelay returns a relay which publishes events of the outbox of db.
+func NewOutboxRelay(db DB, publisher Publisher, opts ...RelayOption) *OutboxRelay {
+	options := relayOptions{
+		batchSize: defaultRelayBatchSize,
+		interval:  defaultRelayInterval,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &OutboxRelay{
+		txManager: NewTxManager(db),
+		publisher: publisher,
+		options:   options,
+	}
+}
+
+// OutboxRelay publishes undelivered events of the outbox and marks them
+// delivered. Events are delivered at least once: an event is published again
+// if the relay fails before the mark is committed. Several relays can run
+// concurrently, each event is locked by one of them.
+type OutboxRelay struct {
+	txManager *TxManager
+	publisher Publisher
+	options   relayOptions
+}
+
+// Run publishes events until the context is done or publishing fails.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		published, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		// The full batch means there may be more undelivered events.
+		if published == r.options.batchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.options.interval)
+		}
+	}
+}
+
+// RelayOnce publishes one batch of undelivered events in the order they were
+// written and returns the number of the published events. When the publisher
+// fails, the events published before the failure are marked delivered.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	published := 0
+
+	// The error of the publisher does not roll back the transaction, so the
+	// events published before the failure stay delivered.
+	var publishErr error
+
+	err := r.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		tx := txFromContext(ctx)
+		publishErr = nil
+
+		events, err := selectUndeliveredEvents(ctx, tx, r.options.batchSize)
+		if err != nil {
+			return err
+		}
+
+		delivered := make([]int64, 0, len(events))
+
+		for _, event := range events {
+			publishErr = r.publisher.Publish(ctx, event)
+			if publishErr != nil {
+				publishErr = fmt.Errorf("failed publishing of event %d: %w", event.ID, publishErr)
+				break
+			}
+
+			delivered = append(delivered, event.ID)
+		}
+
+		if len(delivered) == 0 {
+			return nil
+		}
+
+		const sqlStr = `UPDATE outbox SET delivered_at = now() WHERE event_id = ANY ($1);`
+
+		_, err = tx.ExecContext(ctx, sqlStr, delivered)
+		if err != nil {
+			return fmt.Errorf("failed marking of events as delivered: %w", err)
+		}
+
+		published = len(delivered)
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed relay of outbox: %w", err)
+	}
+
+	if publishErr != nil {
+		return published, fmt.Errorf("failed relay of outbox: %w", publishErr)
+	}
+
+	return published, nil
+}
+
+func selectUndeliveredEvents(ctx context.Context, db DB, limit int) ([]UserEvent, error) {
+	const sqlStr = `SELECT event_id, event_type, user_id, payload, created_at FROM outbox
+WHERE delivered_at IS NULL
+ORDER BY event_id
+LIMIT $1 FOR UPDATE SKIP LOCKED;`
+
+	const format = "failed selection of events from outbox: %w"
+
+	rows, err := db.QueryContext(ctx, sqlStr, limit)
+	if err != nil {
+		return nil, fmt.Errorf(format, err)
+	}
+	defer rows.Close()
+
+	events := make([]UserEvent, 0, limit)
+
+	for rows.Next() {
+		event := UserEvent{}
+
+		var (
+			eventType string
+			payload   []byte
+		)
+
+		err := rows.Scan(&event.ID, &eventType, &event.UserID, &payload, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf(format, err)
+		}
+
+		event.Type = EventType(eventType)
+		event.Payload = payload
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf(format, err)
+	}
+
+	return events, nil
+}
+
+// MemoryPublisher is a Publisher which keeps events in memory, it is useful
+// in tests.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []UserEvent
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, event UserEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, event)
+
+	return nil
+}
+
+// Events returns the published events in the order they were published.
+func (p *MemoryPublisher) Events() []UserEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]UserEvent(nil), p.events...)
+}
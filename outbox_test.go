@@ -0,0 +1,241 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+// publisherFunc is a Publisher which calls the function.
+type publisherFunc func(ctx context.Context, event rootpkg.UserEvent) error
+
+func (f publisherFunc) Publish(ctx context.Context, event rootpkg.UserEvent) error {
+	return f(ctx, event)
+}
+
+func TestOutboxRelay_RelayOnce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	eventTypes := func(events []rootpkg.UserEvent) []rootpkg.EventType {
+		types := make([]rootpkg.EventType, 0, len(events))
+		for _, event := range events {
+			types = append(types, event.Type)
+		}
+
+		return types
+	}
+
+	t.Run("UserCreated is published once after CreateUser", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		publisher := &rootpkg.MemoryPublisher{}
+		relay := rootpkg.NewOutboxRelay(postgres.DB(), publisher)
+
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		// Act
+		published, err := relay.RelayOnce(tenantContext())
+		require.NoError(t, err)
+
+		republished, err := relay.RelayOnce(tenantContext())
+		require.NoError(t, err)
+
+		// Assert
+		require.Equal(t, 1, published)
+		require.Zero(t, republished)
+
+		events := publisher.Events()
+		require.Len(t, events, 1)
+		require.Equal(t, rootpkg.UserCreated, events[0].Type)
+		require.Equal(t, user.ID, events[0].UserID)
+
+		payload := map[string]any{}
+		require.NoError(t, json.Unmarshal(events[0].Payload, &payload))
+		require.Equal(t, user.Username, payload["username"])
+	})
+
+	t.Run("Events of the lifecycle are published in order", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		publisher := &rootpkg.MemoryPublisher{}
+		relay := rootpkg.NewOutboxRelay(postgres.DB(), publisher)
+
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		user.Username = newFullyFiledUser().Username
+
+		require.NoError(t, repo.UpdateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+		require.NoError(t, repo.RestoreUser(tenantContext(), user.ID))
+		require.NoError(t, repo.DeleteUser(tenantContext(), user.ID))
+
+		// Act
+		published, err := relay.RelayOnce(tenantContext())
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 5, published)
+
+		want := []rootpkg.EventType{
+			rootpkg.UserCreated,
+			rootpkg.UserUpdated,
+			rootpkg.UserDeleted,
+			rootpkg.UserRestored,
+			rootpkg.UserDeleted,
+		}
+		require.Equal(t, want, eventTypes(publisher.Events()))
+	})
+
+	t.Run("Event is not written if the change is rolled back", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		txManager := rootpkg.NewTxManager(postgres.DB())
+		publisher := &rootpkg.MemoryPublisher{}
+		relay := rootpkg.NewOutboxRelay(postgres.DB(), publisher)
+
+		errTest := errors.New("test error")
+
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.CreateUser(ctx, newFullyFiledUser()); err != nil {
+				return err
+			}
+
+			return errTest
+		})
+		require.ErrorIs(t, err, errTest)
+
+		// Act
+		published, err := relay.RelayOnce(tenantContext())
+
+		// Assert
+		require.NoError(t, err)
+		require.Zero(t, published)
+		require.Empty(t, publisher.Events())
+	})
+
+	t.Run("Events are published again after failure of the publisher", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		publisher := &rootpkg.MemoryPublisher{}
+		errTest := errors.New("test error")
+
+		calls := 0
+		flaky := publisherFunc(func(ctx context.Context, event rootpkg.UserEvent) error {
+			calls++
+			if calls == 2 {
+				return errTest
+			}
+
+			return publisher.Publish(ctx, event)
+		})
+
+		relay := rootpkg.NewOutboxRelay(postgres.DB(), flaky)
+
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user1))
+		require.NoError(t, repo.CreateUser(tenantContext(), user2))
+
+		// Act
+		published, err := relay.RelayOnce(tenantContext())
+		require.ErrorIs(t, err, errTest)
+		require.Equal(t, 1, published)
+
+		published, err = relay.RelayOnce(tenantContext())
+		require.NoError(t, err)
+		require.Equal(t, 1, published)
+
+		// Assert
+		events := publisher.Events()
+		require.Len(t, events, 2)
+		require.Equal(t, user1.ID, events[0].UserID)
+		require.Equal(t, user2.ID, events[1].UserID)
+	})
+
+	t.Run("Concurrent relays publish every event exactly once", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		publisher := &rootpkg.MemoryPublisher{}
+
+		const count = 20
+
+		for range count {
+			require.NoError(t, repo.CreateUser(tenantContext(), newFullyFiledUser()))
+		}
+
+		relays := []*rootpkg.OutboxRelay{
+			rootpkg.NewOutboxRelay(postgres.DB(), publisher, rootpkg.WithRelayBatchSize(3)),
+			rootpkg.NewOutboxRelay(postgres.DB(), publisher, rootpkg.WithRelayBatchSize(3)),
+		}
+
+		errs := make([]error, len(relays))
+		wg := sync.WaitGroup{}
+
+		// Act
+		for i, relay := range relays {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for {
+					published, err := relay.RelayOnce(tenantContext())
+					if err != nil || published == 0 {
+						errs[i] = err
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		// Assert
+		require.NoError(t, errors.Join(errs...))
+
+		events := publisher.Events()
+		require.Len(t, events, count)
+
+		ids := map[int64]bool{}
+		for _, event := range events {
+			ids[event.ID] = true
+		}
+
+		require.Len(t, ids, count)
+	})
+}
@@ -0,0 +1,394 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/require"
+)
+
+// Tests in this file change environment variables, so they cannot be run in
+// parallel. Instead of a real Postgres they use a local server that speaks
+// just enough of the protocol to complete the startup of a connection.
+
+func TestPostgres_UnixSocket(t *testing.T) {
+	// Unix socket path length is limited to about 100 bytes, so the
+	// directory returned by t.TempDir can be too long.
+	dir, err := os.MkdirTemp("", "testingpg")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, os.RemoveAll(dir))
+	})
+
+	const port = 5432
+
+	socket := filepath.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+	_, startups := startFakePostgres(t, "unix", socket, nil)
+
+	tests := []struct {
+		name       string
+		connString string
+	}{
+		{
+			name:       "Keyword/value DSN",
+			connString: fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres", dir, port),
+		},
+		{
+			name:       "URL with host in query",
+			connString: fmt.Sprintf("postgres:///postgres?host=%s&port=%d", dir, port),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			t.Setenv("TESTING_DB_URL", tt.connString)
+
+			postgres := newPostgres(t, defaultPostgresURL).
+				replaceDBName("isolated").
+				setSearchPath("schema")
+
+			// Act
+			connectByURL(t, postgres)
+			byURL := <-startups
+
+			connectByConfig(t, postgres)
+			byConfig := <-startups
+
+			// Assert
+			for _, startup := range []fakeStartup{byURL, byConfig} {
+				require.False(t, startup.tls)
+				require.Equal(t, "isolated", startup.params["database"])
+				require.Equal(t, "schema", startup.params["search_path"])
+			}
+		})
+	}
+}
+
+func TestPostgres_MutualTLS(t *testing.T) {
+	certs := newTestCertificates(t)
+
+	serverCert, err := tls.LoadX509KeyPair(certs.serverCert, certs.serverKey)
+	require.NoError(t, err)
+
+	addr, startups := startFakePostgres(t, "tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certs.pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	port := addr.(*net.TCPAddr).Port
+
+	tests := []struct {
+		name       string
+		connString string
+	}{
+		{
+			name: "Keyword/value DSN",
+			connString: fmt.Sprintf(
+				"host=localhost port=%d user=postgres dbname=postgres sslmode=verify-full "+
+					"sslrootcert=%s sslcert=%s sslkey=%s",
+				port, certs.rootCert, certs.clientCert, certs.clientKey,
+			),
+		},
+		{
+			name: "URL",
+			connString: fmt.Sprintf(
+				"postgres://postgres@localhost:%d/postgres?sslmode=verify-full"+
+					"&sslrootcert=%s&sslcert=%s&sslkey=%s",
+				port, certs.rootCert, certs.clientCert, certs.clientKey,
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			t.Setenv("TESTING_DB_URL", tt.connString)
+
+			postgres := newPostgres(t, defaultPostgresURL).
+				replaceDBName("isolated").
+				setSearchPath("schema").
+				clone()
+
+			// Act
+			connectByURL(t, postgres)
+			byURL := <-startups
+
+			connectByConfig(t, postgres)
+			byConfig := <-startups
+
+			// Assert
+			for _, startup := range []fakeStartup{byURL, byConfig} {
+				require.True(t, startup.tls)
+				require.Equal(t, "postgres", startup.clientCommonName)
+				require.Equal(t, "isolated", startup.params["database"])
+				require.Equal(t, "schema", startup.params["search_path"])
+			}
+		})
+	}
+
+	t.Run("Connection without client certificate is rejected", func(t *testing.T) {
+		// Arrange
+		connString := fmt.Sprintf(
+			"host=localhost port=%d user=postgres sslmode=verify-full sslrootcert=%s",
+			port, certs.rootCert,
+		)
+
+		ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+
+		// Act
+		_, err := pgconn.Connect(ctx, connString)
+
+		// Assert
+		require.Error(t, err)
+	})
+}
+
+func TestPostgres_LibpqEnvironment(t *testing.T) {
+	// Arrange
+	for _, name := range libpqEnvironment {
+		t.Setenv(name, "")
+	}
+
+	t.Setenv("TESTING_DB_URL", "")
+	t.Setenv("PGPORT", "6543")
+	t.Setenv("PGUSER", "gopher")
+
+	// Act
+	postgres := newPostgres(t, defaultPostgresURL)
+
+	// Assert
+	require.Empty(t, postgres.connString)
+	require.Equal(t, uint16(6543), postgres.config.Port)
+	require.Equal(t, "gopher", postgres.config.User)
+}
+
+func connectByURL(t *testing.T, postgres *Postgres) {
+	ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
+	defer done()
+
+	conn, err := pgconn.Connect(ctx, postgres.URL())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close(ctx))
+}
+
+func connectByConfig(t *testing.T, postgres *Postgres) {
+	ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
+	defer done()
+
+	conn, err := pgconn.ConnectConfig(ctx, &postgres.config.Config)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close(ctx))
+}
+
+type fakeStartup struct {
+	params           map[string]string
+	tls              bool
+	clientCommonName string
+}
+
+// startFakePostgres starts a server which accepts connections, completes the
+// startup and reports the startup parameters of every connection.
+func startFakePostgres(
+	t *testing.T,
+	network string,
+	address string,
+	tlsConfig *tls.Config,
+) (net.Addr, <-chan fakeStartup) {
+	listener, err := net.Listen(network, address)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, listener.Close())
+	})
+
+	startups := make(chan fakeStartup, 1)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveFakeStartup(conn, tlsConfig, startups)
+		}
+	}()
+
+	return listener.Addr(), startups
+}
+
+func serveFakeStartup(conn net.Conn, tlsConfig *tls.Config, startups chan<- fakeStartup) {
+	defer conn.Close()
+
+	startup := fakeStartup{}
+	backend := pgproto3.NewBackend(conn, conn)
+
+	msg, err := backend.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+
+	if _, ok := msg.(*pgproto3.SSLRequest); ok {
+		if tlsConfig == nil {
+			_, _ = conn.Write([]byte("N"))
+		} else {
+			_, _ = conn.Write([]byte("S"))
+
+			tlsConn := tls.Server(conn, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+
+			defer tlsConn.Close()
+
+			startup.tls = true
+			if peers := tlsConn.ConnectionState().PeerCertificates; len(peers) > 0 {
+				startup.clientCommonName = peers[0].Subject.CommonName
+			}
+
+			backend = pgproto3.NewBackend(tlsConn, tlsConn)
+		}
+
+		msg, err = backend.ReceiveStartupMessage()
+		if err != nil {
+			return
+		}
+	}
+
+	message, ok := msg.(*pgproto3.StartupMessage)
+	if !ok {
+		return
+	}
+
+	startup.params = message.Parameters
+
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+
+	if err := backend.Flush(); err != nil {
+		return
+	}
+
+	startups <- startup
+
+	// Wait for the client to terminate the connection.
+	for {
+		if _, err := backend.Receive(); err != nil {
+			return
+		}
+	}
+}
+
+type testCertificates struct {
+	pool *x509.CertPool
+
+	rootCert   string
+	serverCert string
+	serverKey  string
+	clientCert string
+	clientKey  string
+}
+
+// newTestCertificates generates a self-signed certificate authority and
+// certificates signed by it for the server and the client.
+func newTestCertificates(t *testing.T) testCertificates {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "testingpg CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(
+		rand.Reader,
+		caTemplate,
+		caTemplate,
+		&caKey.PublicKey,
+		caKey,
+	)
+	require.NoError(t, err)
+
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	certs := testCertificates{
+		pool:     x509.NewCertPool(),
+		rootCert: filepath.Join(dir, "root.crt"),
+	}
+
+	certs.pool.AddCert(caCert)
+	writePEM(t, certs.rootCert, "CERTIFICATE", caDER)
+
+	issue := func(serial int64, commonName string, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: commonName},
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+
+		keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+
+		certFile := filepath.Join(dir, commonName+".crt")
+		keyFile := filepath.Join(dir, commonName+".key")
+
+		writePEM(t, certFile, "CERTIFICATE", der)
+		writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+
+		return certFile, keyFile
+	}
+
+	certs.serverCert, certs.serverKey = issue(2, "localhost", x509.ExtKeyUsageServerAuth)
+	certs.clientCert, certs.clientKey = issue(3, "postgres", x509.ExtKeyUsageClientAuth)
+
+	return certs
+}
+
+func writePEM(t *testing.T, name string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+
+	require.NoError(t, os.WriteFile(name, data, 0o600))
+}
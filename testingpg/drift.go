@@ -0,0 +1,361 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// columnTypes maps the types of fields to the types of columns as they are
+// described by information_schema.columns.
+var columnTypes = map[reflect.Type]string{
+	reflect.TypeFor[bool]():            "boolean",
+	reflect.TypeFor[int16]():           "smallint",
+	reflect.TypeFor[int32]():           "integer",
+	reflect.TypeFor[int64]():           "bigint",
+	reflect.TypeFor[int]():             "bigint",
+	reflect.TypeFor[float32]():         "real",
+	reflect.TypeFor[float64]():         "double precision",
+	reflect.TypeFor[string]():          "text",
+	reflect.TypeFor[[]byte]():          "bytea",
+	reflect.TypeFor[json.RawMessage](): "jsonb",
+	reflect.TypeFor[time.Time]():       "timestamp with time zone",
+	reflect.TypeFor[uuid.UUID]():       "uuid",
+}
+
+var (
+	// lengthTypeRe matches the type with the length, like character(2).
+	lengthTypeRe = regexp.MustCompile(`^(.+)\((\d+)\)$`)
+	// lengthCheckRe matches the upper bound of the length of the column in the
+	// definition of the CHECK constraint, like char_length((username)::text)
+	// <= 50, to which PostgreSQL also rewrites BETWEEN.
+	lengthCheckRe = regexp.MustCompile(
+		`\b(?:char_length|character_length|length)\(\(?"?(\w+)"?\)?(?:::\w+)?\) (<=?) (\d+)`,
+	)
+)
+
+// column is a column of the table or the column expected by a field of the
+// model.
+type column struct {
+	name     string
+	dataType string
+	// length is the length of the type, like 50 of character varying(50).
+	length   int64
+	nullable bool
+	// varying is set for fields of strings, which are stored by text and by
+	// character varying of any length.
+	varying bool
+	// field is the name of the field of the model.
+	field string
+	// checkLength is the maximum length of the column allowed by the CHECK
+	// constraint named check, zero if there is no such constraint.
+	checkLength int64
+	check       string
+}
+
+func (c column) String() string {
+	dataType := c.dataType
+	if c.length > 0 {
+		dataType += "(" + strconv.FormatInt(c.length, 10) + ")"
+	}
+
+	if c.nullable {
+		return c.name + " " + dataType
+	}
+
+	return c.name + " " + dataType + " NOT NULL"
+}
+
+// matches reports whether the column of the table matches the column of the
+// model by type, length and nullability.
+func (c column) matches(actual column) bool {
+	if c.nullable != actual.nullable {
+		return false
+	}
+
+	if c.varying && actual.dataType == "character varying" {
+		return true
+	}
+
+	return c.dataType == actual.dataType && c.length == actual.length
+}
+
+// RequireNoSchemaDrift fails the test with a diff if the columns of the table
+// in the current schema of db differ from the fields of model, which is a
+// struct or a pointer to a struct, by names, types or nullability, or if the
+// length of VARCHAR differs from the maximum length allowed by the CHECK
+// constraints of the table, like char_length(username) BETWEEN 3 AND 50.
+//
+// The column of a field is set by the db tag, like `db:"user_id"`, by default
+// it is the name of the field in snake case. Unexported fields and fields
+// tagged `db:"-"` are skipped. The type of the column is inferred from the
+// type of the field, fields of strings match both TEXT and VARCHAR, whose
+// length is taken from the table. Fields of pointers are nullable and the
+// others are NOT NULL. The type option sets the type of other columns, like
+// `db:"code,type=character(2)"`.
+//
+// Columns which are not fields of the model, for example columns filled by
+// the database, are listed in ignoredColumns.
+func RequireNoSchemaDrift(
+	t TestingT,
+	db queryer,
+	table string,
+	model any,
+	ignoredColumns ...string,
+) {
+	columns, err := tableColumns(db, table)
+	require.NoError(t, err)
+	require.NotEmpty(t, columns, "table %q does not exist in the current schema", table)
+
+	diff, err := schemaDiff(columns, model, ignoredColumns)
+	require.NoError(t, err)
+
+	if diff == "" {
+		return
+	}
+
+	const format = "columns of table %q differ from %T (-table +model):\n%s"
+
+	t.Errorf(format, table, model, diff)
+	t.FailNow()
+}
+
+// tableColumns returns the columns of the table in order of their positions
+// with the maximum lengths allowed by the CHECK constraints of the table.
+func tableColumns(db queryer, table string) ([]column, error) {
+	const sqlStr = `SELECT column_name, data_type, udt_name, character_maximum_length,
+       is_nullable = 'YES'
+FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = $1
+ORDER BY ordinal_position;`
+
+	rows, err := db.QueryContext(context.Background(), sqlStr, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns := make([]column, 0)
+
+	for rows.Next() {
+		var (
+			c         column
+			udtName   string
+			maxLength sql.NullInt64
+		)
+
+		if err := rows.Scan(&c.name, &c.dataType, &udtName, &maxLength, &c.nullable); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case c.dataType == "USER-DEFINED":
+			c.dataType = udtName
+		case c.dataType == "ARRAY":
+			c.dataType = strings.TrimPrefix(udtName, "_") + "[]"
+		case maxLength.Valid:
+			c.length = maxLength.Int64
+		}
+
+		columns = append(columns, c)
+	}
+
+	if err := rows.Err(); err != nil || len(columns) == 0 {
+		return columns, err
+	}
+
+	return columns, checkLengths(db, table, columns)
+}
+
+// checkLengths sets the maximum lengths of the columns allowed by the CHECK
+// constraints of the table.
+func checkLengths(db queryer, table string, columns []column) error {
+	const sqlStr = `SELECT conname, pg_get_constraintdef(oid)
+FROM pg_constraint
+WHERE conrelid = to_regclass(format('%I.%I', current_schema(), $1::text)) AND contype = 'c'
+ORDER BY conname;`
+
+	rows, err := db.QueryContext(context.Background(), sqlStr, table)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return err
+		}
+
+		for _, match := range lengthCheckRe.FindAllStringSubmatch(definition, -1) {
+			i := slices.IndexFunc(columns, func(c column) bool { return c.name == match[1] })
+			if i < 0 {
+				continue
+			}
+
+			length, err := strconv.ParseInt(match[3], 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if match[2] == "<" {
+				length--
+			}
+
+			if c := &columns[i]; c.checkLength == 0 || length < c.checkLength {
+				c.checkLength, c.check = length, name
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// modelColumns returns the columns expected by the fields of the model.
+func modelColumns(model any) ([]column, error) {
+	typ := reflect.TypeOf(model)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("model must be a struct, got %T", model)
+	}
+
+	columns := make([]column, 0, typ.NumField())
+
+	for field := range typ.Fields() {
+		tag := field.Tag.Get("db")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = snakeCase(field.Name)
+		}
+
+		c := column{name: name, field: typ.Name() + "." + field.Name}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+			c.nullable = true
+		}
+
+		c.dataType = columnTypes[fieldType]
+		c.varying = fieldType.Kind() == reflect.String
+
+		for option := range strings.SplitSeq(options, ",") {
+			key, value, _ := strings.Cut(option, "=")
+
+			switch key {
+			case "":
+			case "type":
+				c.dataType, c.length, c.varying = value, 0, false
+
+				if match := lengthTypeRe.FindStringSubmatch(value); match != nil {
+					c.dataType = match[1]
+					c.length, _ = strconv.ParseInt(match[2], 10, 64)
+				}
+			default:
+				return nil, fmt.Errorf("unknown option %q of the field %s", key, c.field)
+			}
+		}
+
+		if c.dataType == "" {
+			const format = "type %s of the field %s is not supported, set it by the type option"
+			return nil, fmt.Errorf(format, field.Type, c.field)
+		}
+
+		columns = append(columns, c)
+	}
+
+	return columns, nil
+}
+
+// schemaDiff returns the lines of the columns of the table, prefixed by "-",
+// which differ from the columns of the model, prefixed by "+", or the empty
+// string if there is no difference.
+func schemaDiff(tableColumns []column, model any, ignoredColumns []string) (string, error) {
+	expected, err := modelColumns(model)
+	if err != nil {
+		return "", err
+	}
+
+	diff := strings.Builder{}
+
+	for _, actual := range tableColumns {
+		if slices.Contains(ignoredColumns, actual.name) {
+			continue
+		}
+
+		if actual.length > 0 && actual.checkLength > 0 && actual.length != actual.checkLength {
+			checked := actual
+			checked.length = actual.checkLength
+
+			fmt.Fprintf(&diff, "- %s\n+ %s (CHECK %s)\n", actual, checked, actual.check)
+		}
+
+		i := slices.IndexFunc(expected, func(c column) bool { return c.name == actual.name })
+		if i < 0 {
+			fmt.Fprintf(&diff, "- %s\n", actual)
+			continue
+		}
+
+		want := expected[i]
+		expected = slices.Delete(expected, i, i+1)
+
+		if !want.matches(actual) {
+			fmt.Fprintf(&diff, "- %s\n+ %s (%s)\n", actual, want, want.field)
+		}
+	}
+
+	for _, want := range expected {
+		fmt.Fprintf(&diff, "+ %s (%s)\n", want, want.field)
+	}
+
+	return diff.String(), nil
+}
+
+// snakeCase returns the name in snake case, like user_id for UserID.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	output := strings.Builder{}
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := !unicode.IsUpper(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if prevLower || nextLower {
+				output.WriteRune('_')
+			}
+		}
+
+		output.WriteRune(unicode.ToLower(r))
+	}
+
+	return output.String()
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/stretchr/testify/require"
+)
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// requireExtensions fails the test if any of the extensions is not installed
+// in the database of db.
+func (p *Postgres) requireExtensions(db queryRower, names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	const sqlStr = `SELECT coalesce(string_agg(name, ', ' ORDER BY name), '')
+FROM unnest($1::text[]) AS name
+WHERE name NOT IN (SELECT extname FROM pg_extension);`
+
+	missing := ""
+
+	err := db.QueryRowContext(context.Background(), sqlStr, names).Scan(&missing)
+	require.NoError(p.t, err)
+
+	require.Empty(
+		p.t,
+		missing,
+		"extensions %s are not installed in the database %q",
+		missing,
+		p.config.Database,
+	)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+)
+
+// NewLogger returns a logger of all levels whose records are written by t.Log
+// after the test is completed, only if the test has failed. The records of
+// passed tests are dropped, so they do not clutter the output even with -v.
+func NewLogger(t TestingT) *slog.Logger {
+	w := &bufferedLog{}
+
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+
+		for _, line := range w.lines() {
+			t.Log(line)
+		}
+	})
+
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// bufferedLog keeps the lines written by slog.TextHandler, which writes every
+// record by a single call of Write.
+type bufferedLog struct {
+	mu  sync.Mutex
+	buf []string
+}
+
+func (b *bufferedLog) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, string(bytes.TrimSuffix(p, []byte("\n"))))
+
+	return len(p), nil
+}
+
+func (b *bufferedLog) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string(nil), b.buf...)
+}
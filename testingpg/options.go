@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// Option configures handles created by the constructors of the package,
+// options which are not applicable to a constructor are ignored by it.
+type Option func(o *options)
+
+type options struct {
+	appRole   string
+	reference string
+
+	isolation  sql.IsolationLevel
+	readOnly   bool
+	deferrable bool
+
+	statementTimeout time.Duration
+	lockTimeout      time.Duration
+	idleTimeout      time.Duration
+
+	watchdogMargin time.Duration
+
+	extensions []string
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		isolation:      sql.LevelRepeatableRead,
+		watchdogMargin: 10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// sessionSettings returns the run-time parameters set by the options.
+func (o options) sessionSettings() map[string]string {
+	settings := map[string]string{}
+
+	setTimeout := func(name string, timeout time.Duration) {
+		if timeout > 0 {
+			settings[name] = strconv.FormatInt(timeout.Milliseconds(), 10)
+		}
+	}
+
+	setTimeout("statement_timeout", o.statementTimeout)
+	setTimeout("lock_timeout", o.lockTimeout)
+	setTimeout("idle_in_transaction_session_timeout", o.idleTimeout)
+
+	return settings
+}
+
+// WithDedicatedRole makes the handle authenticate as a unique login role
+// created for the test. The role is a member of appRole, so it has only the
+// privileges granted to appRole, for example by migrations. The role is
+// dropped after the test is completed.
+func WithDedicatedRole(appRole string) Option {
+	return func(o *options) {
+		o.appRole = appRole
+	}
+}
+
+// WithIsolationLevel sets the isolation level of the transaction created by
+// NewWithTransactionalCleanup, by default it is sql.LevelRepeatableRead.
+func WithIsolationLevel(level sql.IsolationLevel) Option {
+	return func(o *options) {
+		o.isolation = level
+	}
+}
+
+// WithReadOnly makes the transaction created by NewWithTransactionalCleanup
+// read-only.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithDeferrable makes the transaction created by NewWithTransactionalCleanup
+// deferrable, it has an effect only for serializable read-only transactions.
+func WithDeferrable() Option {
+	return func(o *options) {
+		o.deferrable = true
+	}
+}
+
+// WithStatementTimeout aborts any statement that takes more than timeout, so
+// a hung query fails the test instead of hanging it.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = timeout
+	}
+}
+
+// WithLockTimeout aborts any statement that waits longer than timeout while
+// attempting to acquire a lock.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithIdleInTransactionSessionTimeout terminates any session that has been
+// idle within an open transaction for longer than timeout.
+func WithIdleInTransactionSessionTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithWatchdogMargin sets how long before the deadline of the test the
+// watchdog logs the activity and the locks of the sessions of the handle and
+// cancels their queries, by default it is 10 seconds. Zero disables the
+// watchdog.
+func WithWatchdogMargin(margin time.Duration) Option {
+	return func(o *options) {
+		o.watchdogMargin = margin
+	}
+}
+
+// WithExtensions fails the test if any of the extensions is not installed in
+// the database of the handle. Isolated databases are cloned from the
+// template database, which carries its extensions over to every clone, so the
+// extensions are expected to be installed in the template, for example by
+// migrations, instead of by every test with superuser privileges.
+func WithExtensions(names ...string) Option {
+	return func(o *options) {
+		o.extensions = append(o.extensions, names...)
+	}
+}
+
+// WithReferenceDatabase makes NewWithIsolatedDatabase clone the database from
+// the database name instead of the reference database set by TESTING_DB_REF,
+// for example from template0 to get an empty database without migrations.
+func WithReferenceDatabase(name string) Option {
+	return func(o *options) {
+		o.reference = name
+	}
+}
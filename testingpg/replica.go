@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type ReplicaOption func(r *replicaOptions)
+
+type replicaOptions struct {
+	delay time.Duration
+}
+
+// WithQueryDelay delays every query sent through Replica.DB by delay, it
+// allows simulating a slow replica and testing timeouts of reads routed to
+// the replica. The delay is interrupted when the context of the query is done.
+// It is not replication lag: the replica reads the same database as the
+// primary, so changes of the primary are visible immediately.
+func WithQueryDelay(delay time.Duration) ReplicaOption {
+	return func(r *replicaOptions) {
+		r.delay = delay
+	}
+}
+
+// Replica returns a handle to the same database as p, which behaves as a
+// read-only replica: every transaction is started with read only access mode
+// so any attempt to modify data fails with SQLSTATE 25006.
+func (p *Postgres) Replica(opts ...ReplicaOption) *Postgres {
+	options := replicaOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	o := p.clone()
+	o.config.RuntimeParams["default_transaction_read_only"] = "on"
+
+	if options.delay > 0 {
+		o.config.Tracer = queryDelayTracer{delay: options.delay}
+	}
+
+	return o
+}
+
+type queryDelayTracer struct {
+	delay time.Duration
+}
+
+func (r queryDelayTracer) TraceQueryStart(
+	ctx context.Context,
+	_ *pgx.Conn,
+	_ pgx.TraceQueryStartData,
+) context.Context {
+	timer := time.NewTimer(r.delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	return ctx
+}
+
+func (r queryDelayTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type role struct {
+	name     string
+	password string
+}
+
+// createRole creates a unique login role which inherits privileges of the
+// appRole, the role is dropped after the test is completed.
+func (p *Postgres) createRole(appRole string) role {
+	r := role{
+		name:     newUniqueHumanReadableDatabaseName(p.t),
+		password: genPassword(p.t),
+	}
+
+	p.t.Log("role name for this test:", r.name)
+
+	sql := fmt.Sprintf(
+		`CREATE ROLE %q LOGIN PASSWORD '%s' IN ROLE %q;`,
+		r.name,
+		r.password,
+		appRole,
+	)
+
+	_, err := p.DB().ExecContext(context.Background(), sql)
+	require.NoError(p.t, err)
+
+	p.t.Cleanup(func() {
+		sql := fmt.Sprintf(`DROP ROLE %q;`, r.name)
+
+		ctx, done := context.WithTimeout(context.Background(), time.Minute)
+		defer done()
+
+		_, err := p.DB().ExecContext(ctx, sql)
+		require.NoError(p.t, err)
+	})
+
+	return r
+}
+
+// loginAs returns a handle to the same database, which authenticates as the
+// role, the handle p is kept as the owner of the new handle.
+func (p *Postgres) loginAs(r role) *Postgres {
+	o := p.clone()
+	o.owner = p
+	o.config.User = r.name
+	o.config.Password = r.password
+
+	return o
+}
+
+// Owner returns a handle which authenticates as the owner of the isolated
+// database or schema, for example to apply migrations when the handle was
+// created with the WithDedicatedRole option. Otherwise, it returns p.
+func (p *Postgres) Owner() *Postgres {
+	if p.owner != nil {
+		return p.owner
+	}
+
+	return p
+}
+
+func genPassword(t TestingT) string {
+	bs := make([]byte, 24)
+
+	_, err := rand.Read(bs)
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
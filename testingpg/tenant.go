@@ -0,0 +1,38 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TenantSetting is the run-time parameter set by Tenant, row-level security
+// policies are expected to compare it with the tenant of the rows.
+const TenantSetting = "app.tenant_id"
+
+// Tenant returns a handle to the same database as p, whose sessions have
+// TenantSetting set to tenantID, so row-level security policies keyed on it
+// scope every query of the handle to the tenant.
+//
+// Superusers, roles with BYPASSRLS and owners of tables are not subject to
+// the policies, so the test fails if the handle authenticates as a superuser
+// or a role with BYPASSRLS. Use WithDedicatedRole to authenticate as a role
+// to which the policies apply.
+func (p *Postgres) Tenant(tenantID string) *Postgres {
+	o := p.clone()
+	o.config.RuntimeParams[TenantSetting] = tenantID
+
+	const sqlStr = `SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user;`
+
+	bypassRLS := false
+
+	err := o.DB().QueryRowContext(context.Background(), sqlStr).Scan(&bypassRLS)
+	require.NoError(p.t, err)
+	require.False(p.t, bypassRLS, "row-level security does not apply to the role of the handle")
+
+	return o
+}
@@ -6,8 +6,18 @@ package testingpg_test
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/require"
 
@@ -224,4 +234,861 @@ func TestNewWithTransactionalCleanup(t *testing.T) {
 		// Assert
 		require.NoError(t, err, "side effects must be isolated for each instance")
 	})
+
+	t.Run("Isolation level and access mode are configurable", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := testingpg.NewWithTransactionalCleanup(t,
+			testingpg.WithIsolationLevel(sql.LevelSerializable),
+			testingpg.WithReadOnly(),
+			testingpg.WithDeferrable(),
+		)
+
+		ctx := context.Background()
+
+		// Act
+		var isolation, readOnly, deferrable string
+		err := tx.QueryRowContext(ctx, `SELECT
+			current_setting('transaction_isolation'),
+			current_setting('transaction_read_only'),
+			current_setting('transaction_deferrable');`,
+		).Scan(&isolation, &readOnly, &deferrable)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, "serializable", isolation)
+		require.Equal(t, "on", readOnly)
+		require.Equal(t, "on", deferrable)
+	})
+
+	t.Run("Hung query is canceled by statement timeout", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		tx := testingpg.NewWithTransactionalCleanup(t,
+			testingpg.WithStatementTimeout(100*time.Millisecond),
+		)
+
+		// Act
+		_, err := tx.ExecContext(context.Background(), "SELECT pg_sleep(10);")
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, "57014", pgErr.Code, "query_canceled")
+	})
+
+	t.Run("Waiting for a lock is canceled by lock timeout", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		// The tenant is inserted explicitly, because no tenant is set in the
+		// transactions, and both transactions use the same tenant, so that the
+		// username conflicts.
+		const sqlStr = `INSERT INTO users (user_id, tenant_id, username, created_at)
+			VALUES (gen_random_uuid(), '00000000-0000-0000-0000-000000000000', $1, now());`
+
+		username := fmt.Sprintf("lock-%d", time.Now().UnixNano())
+		ctx := context.Background()
+
+		tx1 := testingpg.NewWithTransactionalCleanup(t)
+		tx2 := testingpg.NewWithTransactionalCleanup(t,
+			testingpg.WithLockTimeout(100*time.Millisecond),
+		)
+
+		_, err := tx1.ExecContext(ctx, sqlStr, username)
+		require.NoError(t, err)
+
+		// Act
+		_, err = tx2.ExecContext(ctx, sqlStr, username)
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, "55P03", pgErr.Code, "lock_not_available")
+	})
+}
+
+func TestNewWithIsolatedDatabase_ConnectionFormats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	// Environment variables are changed, so tests cannot be run in parallel.
+
+	newBaseConfig := func(t *testing.T) *pgx.ConnConfig {
+		config, err := pgx.ParseConfig(testingpg.NewWithIsolatedDatabase(t).URL())
+		require.NoError(t, err)
+
+		return config
+	}
+
+	currentDatabase := func(t *testing.T, postgres *testingpg.Postgres) string {
+		const sqlStr = "SELECT current_database();"
+
+		var name string
+
+		err := postgres.DB().QueryRowContext(context.Background(), sqlStr).Scan(&name)
+		require.NoError(t, err)
+
+		return name
+	}
+
+	t.Run("Successfully connect by keyword/value DSN", func(t *testing.T) {
+		// Arrange
+		base := newBaseConfig(t)
+
+		dsn := fmt.Sprintf(
+			"host=%s port=%d user=%s password='%s' dbname=%s sslmode=disable",
+			base.Host, base.Port, base.User, base.Password, base.Database,
+		)
+
+		t.Setenv("TESTING_DB_URL", dsn)
+
+		// Act
+		postgres := testingpg.NewWithIsolatedDatabase(t)
+
+		// Assert
+		config, err := pgx.ParseConfig(postgres.URL())
+		require.NoError(t, err)
+		require.NotEqual(t, base.Database, config.Database)
+		require.Equal(t, config.Database, currentDatabase(t, postgres))
+	})
+
+	t.Run("Successfully connect by libpq environment variables", func(t *testing.T) {
+		// Arrange
+		base := newBaseConfig(t)
+
+		t.Setenv("TESTING_DB_URL", "")
+		t.Setenv("PGHOST", base.Host)
+		t.Setenv("PGPORT", strconv.Itoa(int(base.Port)))
+		t.Setenv("PGUSER", base.User)
+		t.Setenv("PGPASSWORD", base.Password)
+		t.Setenv("PGDATABASE", base.Database)
+		t.Setenv("PGSSLMODE", "disable")
+
+		// Act
+		postgres := testingpg.NewWithIsolatedSchema(t)
+
+		// Assert
+		config, err := pgx.ParseConfig(postgres.URL())
+		require.NoError(t, err)
+		require.Equal(t, base.Database, currentDatabase(t, postgres))
+		require.NotEmpty(t, config.RuntimeParams["search_path"])
+	})
+}
+
+func TestPostgres_Replica(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Changes of primary are visible in replica", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		primary := testingpg.NewWithIsolatedDatabase(t)
+		replica := primary.Replica()
+
+		ctx := context.Background()
+
+		_, err := primary.DB().ExecContext(ctx, `CREATE TABLE "replicated" (id integer)`)
+		require.NoError(t, err)
+
+		_, err = primary.DB().ExecContext(ctx, `INSERT INTO "replicated" VALUES (1)`)
+		require.NoError(t, err)
+
+		// Act
+		var id int
+		err = replica.DB().QueryRowContext(ctx, `SELECT id FROM "replicated"`).Scan(&id)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 1, id)
+	})
+
+	t.Run("Writes to replica are rejected", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		replica := testingpg.NewWithIsolatedSchema(t).Replica()
+
+		// Act
+		_, err := replica.DB().ExecContext(
+			context.Background(),
+			`CREATE TABLE "read_only" (id integer)`,
+		)
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, "25006", pgErr.Code, "read_only_sql_transaction")
+	})
+
+	t.Run("URL of replica is read-only", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		replica := testingpg.NewWithIsolatedDatabase(t).Replica()
+
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, replica.URL())
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, conn.Close(ctx))
+		})
+
+		// Act
+		var readOnly string
+		err = conn.QueryRow(ctx, "SHOW default_transaction_read_only;").Scan(&readOnly)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, "on", readOnly)
+	})
+
+	t.Run("Reads from slow replica are delayed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		const delay = time.Second
+
+		replica := testingpg.NewWithIsolatedDatabase(t).Replica(testingpg.WithQueryDelay(delay))
+
+		ctx, done := context.WithTimeout(context.Background(), delay/10)
+		defer done()
+
+		// Act
+		var version string
+		err := replica.DB().QueryRowContext(ctx, "SELECT version();").Scan(&version)
+
+		// Assert
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestPostgres_Conn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Connection is not shared with the pool", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t)
+		ctx := context.Background()
+
+		conn, err := postgres.Conn(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, conn.Close(context.Background())) })
+
+		// Act
+		var connPID, poolPID uint32
+		err = conn.QueryRow(ctx, "SELECT pg_backend_pid();").Scan(&connPID)
+		require.NoError(t, err)
+
+		err = postgres.DB().QueryRowContext(ctx, "SELECT pg_backend_pid();").Scan(&poolPID)
+		require.NoError(t, err)
+
+		// Assert
+		require.NotEqual(t, connPID, poolPID)
+		require.Equal(t, 1, postgres.DB().Stats().OpenConnections)
+	})
+
+	t.Run("Connection receives notifications", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedSchema(t)
+		ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+
+		conn, err := postgres.Conn(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, conn.Close(context.Background())) })
+
+		channel := strings.ReplaceAll(t.Name(), "/", "_")
+
+		_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+		require.NoError(t, err)
+
+		// Act
+		_, err = postgres.DB().ExecContext(ctx, "SELECT pg_notify($1, 'payload');", channel)
+		require.NoError(t, err)
+
+		notification, err := conn.WaitForNotification(ctx)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, channel, notification.Channel)
+		require.Equal(t, "payload", notification.Payload)
+	})
+}
+
+func TestPostgres_Tenant(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	constructors := map[string]func(t *testing.T) *testingpg.Postgres{
+		"Database": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		},
+		"Schema": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedSchema(t, testingpg.WithDedicatedRole("app"))
+		},
+	}
+
+	for name, newPostgres := range constructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			postgres := newPostgres(t)
+			tenant := postgres.Tenant("tenant-" + name)
+
+			// Act
+			var setting string
+			err := tenant.DB().QueryRowContext(
+				context.Background(),
+				"SELECT current_setting('"+testingpg.TenantSetting+"');",
+			).Scan(&setting)
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, "tenant-"+name, setting)
+
+			var user, tenantUser string
+
+			err = postgres.DB().QueryRowContext(context.Background(), "SELECT current_user;").Scan(&user)
+			require.NoError(t, err)
+
+			err = tenant.DB().QueryRowContext(context.Background(), "SELECT current_user;").Scan(&tenantUser)
+			require.NoError(t, err)
+
+			require.Equal(t, user, tenantUser, "the tenant handle must keep the role")
+		})
+	}
+}
+
+// recordingT is a TestingT whose failure is set by the test and whose cleanups
+// and logs are recorded instead of being run and printed.
+type recordingT struct {
+	*testing.T
+
+	failed   bool
+	cleanups []func()
+	logs     []string
+}
+
+func (r *recordingT) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *recordingT) Log(args ...any) {
+	r.logs = append(r.logs, fmt.Sprint(args...))
+}
+
+func (r *recordingT) Failed() bool {
+	return r.failed
+}
+
+func (r *recordingT) runCleanups() {
+	for _, f := range slices.Backward(r.cleanups) {
+		f()
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Records are logged if the test has failed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		recorder := &recordingT{T: t}
+		logger := testingpg.NewLogger(recorder)
+
+		logger.Debug("statement", "sql", "SELECT 1;")
+		logger.Error("statement", "sql", "SELECT 2;")
+
+		recorder.failed = true
+
+		// Act
+		recorder.runCleanups()
+
+		// Assert
+		require.Len(t, recorder.logs, 2)
+		require.Contains(t, recorder.logs[0], "level=DEBUG")
+		require.Contains(t, recorder.logs[0], `sql="SELECT 1;"`)
+		require.Contains(t, recorder.logs[1], "level=ERROR")
+	})
+
+	t.Run("Records are dropped if the test has passed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		recorder := &recordingT{T: t}
+		logger := testingpg.NewLogger(recorder)
+
+		logger.Error("statement", "sql", "SELECT 1;")
+
+		// Act
+		recorder.runCleanups()
+
+		// Assert
+		require.Empty(t, recorder.logs)
+	})
+}
+
+func TestWithDedicatedRole(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	// appRole is created by migrations, see the migrations directory.
+	const appRole = "app"
+
+	constructors := map[string]func(t *testing.T) *testingpg.Postgres{
+		"Database": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole(appRole))
+		},
+		"Schema": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedSchema(t, testingpg.WithDedicatedRole(appRole))
+		},
+	}
+
+	currentUser := func(t *testing.T, db *sql.DB) string {
+		var user string
+
+		err := db.QueryRowContext(context.Background(), "SELECT current_user;").Scan(&user)
+		require.NoError(t, err)
+
+		return user
+	}
+
+	for name, newPostgres := range constructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("Role is unique for each instance", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres1 := newPostgres(t)
+				postgres2 := newPostgres(t)
+
+				// Act
+				user1 := currentUser(t, postgres1.DB())
+				user2 := currentUser(t, postgres2.DB())
+
+				// Assert
+				require.NotEqual(t, user1, user2)
+				require.NotEqual(t, currentUser(t, postgres1.Owner().DB()), user1)
+			})
+
+			t.Run("URL authenticates as dedicated role", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := newPostgres(t)
+
+				ctx := context.Background()
+				conn, err := pgx.Connect(ctx, postgres.URL())
+				require.NoError(t, err)
+
+				t.Cleanup(func() {
+					require.NoError(t, conn.Close(ctx))
+				})
+
+				// Act
+				var user string
+				err = conn.QueryRow(ctx, "SELECT current_user;").Scan(&user)
+
+				// Assert
+				require.NoError(t, err)
+				require.Equal(t, currentUser(t, postgres.DB()), user)
+			})
+
+			t.Run("Role inherits privileges of application role", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := newPostgres(t)
+
+				const sqlStr = `SELECT pg_has_role(current_user, $1, 'USAGE');`
+
+				// Act
+				var member bool
+				err := postgres.DB().QueryRowContext(context.Background(), sqlStr, appRole).
+					Scan(&member)
+
+				// Assert
+				require.NoError(t, err)
+				require.True(t, member)
+			})
+
+			t.Run("Role has no privileges to create tables", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := newPostgres(t)
+
+				// Act
+				_, err := postgres.DB().ExecContext(
+					context.Background(),
+					`CREATE TABLE "not_allowed" (id integer PRIMARY KEY)`,
+				)
+
+				// Assert
+				pgErr := &pgconn.PgError{}
+				require.ErrorAs(t, err, &pgErr)
+				require.Equal(t, "42501", pgErr.Code, "insufficient_privilege")
+			})
+
+			t.Run("Owner has privileges to create tables", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := newPostgres(t)
+
+				// Act
+				_, err := postgres.Owner().DB().ExecContext(
+					context.Background(),
+					`CREATE TABLE "allowed" (id integer PRIMARY KEY)`,
+				)
+
+				// Assert
+				require.NoError(t, err)
+			})
+		})
+	}
+}
+
+func TestWithExtensions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		new  func(t *testing.T) *testingpg.Postgres
+	}{
+		{
+			name: "Isolated database",
+			new: func(t *testing.T) *testingpg.Postgres {
+				return testingpg.NewWithIsolatedDatabase(t, testingpg.WithExtensions("plpgsql"))
+			},
+		},
+		{
+			name: "Isolated schema",
+			new: func(t *testing.T) *testingpg.Postgres {
+				return testingpg.NewWithIsolatedSchema(t, testingpg.WithExtensions("plpgsql"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			postgres := tt.new(t)
+
+			// Act
+			var schema string
+			err := postgres.DB().QueryRowContext(
+				context.Background(),
+				`SELECT n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+WHERE e.extname = 'plpgsql';`,
+			).Scan(&schema)
+
+			// Assert
+			require.NoError(t, err)
+			require.Equal(t, "pg_catalog", schema)
+		})
+	}
+
+	t.Run("Search path of isolated schema is limited to the schema", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedSchema(t)
+
+		// Act
+		var (
+			count  int
+			schema string
+		)
+
+		err := postgres.DB().QueryRowContext(
+			context.Background(),
+			`SELECT array_length(current_schemas(false), 1), current_schema();`,
+		).Scan(&count, &schema)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		require.NotEqual(t, "public", schema)
+	})
+}
+
+func TestWithReferenceDatabase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Database is cloned from the given database", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithReferenceDatabase("template0"))
+
+		// Act
+		var table sql.NullString
+		err := postgres.DB().QueryRowContext(
+			context.Background(),
+			`SELECT to_regclass('users')::text;`,
+		).Scan(&table)
+
+		// Assert
+		require.NoError(t, err)
+		require.False(t, table.Valid, "migrations are not applied to template0")
+	})
+}
+
+func TestRequireNoSchemaDrift(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Table of the isolated schema matches the model", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedSchema(t)
+
+		_, err := postgres.DB().ExecContext(context.Background(), `CREATE TABLE users
+(
+    user_id    uuid PRIMARY KEY,
+    username   VARCHAR(50) NOT NULL CHECK (char_length(username) BETWEEN 3 AND 50),
+    tags       text[]      NOT NULL,
+    created_at timestamptz NOT NULL,
+    deleted_at timestamptz
+);`)
+		require.NoError(t, err)
+
+		type user struct {
+			ID        uuid.UUID  `db:"user_id"`
+			Username  string     `db:"username"`
+			Tags      []string   `db:"tags,type=text[]"`
+			CreatedAt time.Time  `db:"created_at"`
+			DeletedAt *time.Time `db:"deleted_at"`
+		}
+
+		// Assert
+		testingpg.RequireNoSchemaDrift(t, postgres.DB(), "users", user{})
+	})
+}
+
+func TestWithStatementTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	timeouts := []testingpg.Option{
+		testingpg.WithStatementTimeout(100 * time.Millisecond),
+		testingpg.WithLockTimeout(200 * time.Millisecond),
+		testingpg.WithIdleInTransactionSessionTimeout(time.Minute),
+	}
+
+	constructors := map[string]func(t *testing.T) *testingpg.Postgres{
+		"Database": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedDatabase(t, timeouts...)
+		},
+		"Schema": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedSchema(t, timeouts...)
+		},
+		"Replica": func(t *testing.T) *testingpg.Postgres {
+			return testingpg.NewWithIsolatedDatabase(t, timeouts...).Replica()
+		},
+	}
+
+	for name, newPostgres := range constructors {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("Timeouts are set for sessions", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := newPostgres(t)
+
+				// Act
+				var statement, lock, idle string
+				err := postgres.DB().QueryRowContext(context.Background(), `SELECT
+					current_setting('statement_timeout'),
+					current_setting('lock_timeout'),
+					current_setting('idle_in_transaction_session_timeout');`,
+				).Scan(&statement, &lock, &idle)
+
+				// Assert
+				require.NoError(t, err)
+				require.Equal(t, "100ms", statement)
+				require.Equal(t, "200ms", lock)
+				require.Equal(t, "1min", idle)
+			})
+
+			t.Run("Hung query is canceled by statement timeout", func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := newPostgres(t)
+
+				// Act
+				_, err := postgres.DB().ExecContext(context.Background(), "SELECT pg_sleep(10);")
+
+				// Assert
+				pgErr := &pgconn.PgError{}
+				require.ErrorAs(t, err, &pgErr)
+				require.Equal(t, "57014", pgErr.Code, "query_canceled")
+			})
+		})
+	}
+}
+
+// deadlineT is a testing.T with the deadline which is about to expire, it
+// collects all logged messages.
+type deadlineT struct {
+	*testing.T
+
+	deadline time.Time
+
+	mu   sync.Mutex
+	logs []string
+}
+
+func (d *deadlineT) Deadline() (time.Time, bool) {
+	return d.deadline, true
+}
+
+func (d *deadlineT) Log(args ...any) {
+	d.Logf("%s", strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func (d *deadlineT) Logf(format string, args ...any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.logs = append(d.logs, fmt.Sprintf(format, args...))
+	d.T.Logf(format, args...)
+}
+
+func (d *deadlineT) output() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return strings.Join(d.logs, "\n")
+}
+
+func TestWithWatchdogMargin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	const margin = time.Second
+
+	newDeadlineT := func(t *testing.T) *deadlineT {
+		return &deadlineT{T: t, deadline: time.Now().Add(margin + margin/2)}
+	}
+
+	t.Run("Hung query is dumped and canceled", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		dt := newDeadlineT(t)
+		postgres := testingpg.NewWithIsolatedDatabase(dt, testingpg.WithWatchdogMargin(margin))
+
+		// Act
+		_, err := postgres.DB().ExecContext(context.Background(), "SELECT pg_sleep(30);")
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, "57014", pgErr.Code, "query_canceled")
+
+		output := dt.output()
+		require.Contains(t, output, "pg_stat_activity:")
+		require.Contains(t, output, "pg_sleep(30)")
+		require.Contains(t, output, "pg_locks:")
+	})
+
+	t.Run("Lock waiting transaction is dumped and canceled", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		// The tenant is inserted explicitly, because no tenant is set in the
+		// transactions, and both transactions use the same tenant, so that the
+		// username conflicts.
+		const sqlStr = `INSERT INTO users (user_id, tenant_id, username, created_at)
+			VALUES (gen_random_uuid(), '00000000-0000-0000-0000-000000000000', $1, now());`
+
+		username := fmt.Sprintf("watchdog-%d", time.Now().UnixNano())
+		ctx := context.Background()
+
+		dt := newDeadlineT(t)
+		tx1 := testingpg.NewWithTransactionalCleanup(t)
+		tx2 := testingpg.NewWithTransactionalCleanup(dt, testingpg.WithWatchdogMargin(margin))
+
+		_, err := tx1.ExecContext(ctx, sqlStr, username)
+		require.NoError(t, err)
+
+		// Act
+		_, err = tx2.ExecContext(ctx, sqlStr, username)
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, "57014", pgErr.Code, "query_canceled")
+
+		output := dt.output()
+		require.Contains(t, output, "granted=false")
+		require.Contains(t, output, "pg_cancel_backend:")
+	})
+
+	t.Run("Watchdog is disabled by zero margin", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		dt := newDeadlineT(t)
+		postgres := testingpg.NewWithIsolatedSchema(dt, testingpg.WithWatchdogMargin(0))
+
+		// Act
+		_, err := postgres.DB().ExecContext(context.Background(), "SELECT pg_sleep(2);")
+
+		// Assert
+		require.NoError(t, err)
+		require.NotContains(t, dt.output(), "pg_stat_activity:")
+	})
 }
@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testingpg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// watch returns a handle to the same database as handle, whose sessions are
+// marked with a unique application_name. When the deadline of the test
+// approaches, the watchdog logs the activity and the locks of these sessions
+// and cancels their queries, so that a test which deadlocked fails with
+// diagnostics instead of hanging until the timeout of go test.
+func (p *Postgres) watch(handle *Postgres, options options) *Postgres {
+	applicationName := newUniqueHumanReadableDatabaseName(p.t)
+
+	o := handle.clone()
+	o.config.RuntimeParams["application_name"] = applicationName
+
+	deadliner, ok := p.t.(interface{ Deadline() (time.Time, bool) })
+	if !ok || options.watchdogMargin <= 0 {
+		return o
+	}
+
+	deadline, ok := deadliner.Deadline()
+	if !ok {
+		return o
+	}
+
+	wait := time.Until(deadline) - options.watchdogMargin
+	if wait <= 0 {
+		return o
+	}
+
+	// The connection is opened in advance, so that it is closed after the
+	// watchdog is stopped.
+	db := p.DB()
+	fired := make(chan struct{})
+
+	timer := time.AfterFunc(wait, func() {
+		defer close(fired)
+
+		ctx, done := context.WithTimeout(context.Background(), options.watchdogMargin/2)
+		defer done()
+
+		p.t.Logf("deadline of the test is approaching, sessions %q are dumped", applicationName)
+
+		dumpWatchedSessions(ctx, p.t, db, applicationName)
+	})
+
+	p.t.Cleanup(func() {
+		if !timer.Stop() {
+			<-fired
+		}
+	})
+
+	return o
+}
+
+func dumpWatchedSessions(ctx context.Context, t TestingT, db *sql.DB, applicationName string) {
+	const activity = `SELECT pid, datname, state, wait_event_type, wait_event,
+       backend_xid, (now() - query_start)::text AS duration, query
+FROM pg_stat_activity
+WHERE application_name = $1 AND pid <> pg_backend_pid()
+ORDER BY pid;`
+
+	const locks = `SELECT l.pid, l.locktype, l.database, l.relation, l.transactionid,
+       l.mode, l.granted, pg_blocking_pids(l.pid)::text AS blocked_by
+FROM pg_locks l
+         JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE a.application_name = $1 AND a.pid <> pg_backend_pid()
+ORDER BY l.pid, l.granted;`
+
+	const cancel = `SELECT pid, pg_cancel_backend(pid)
+FROM pg_stat_activity
+WHERE application_name = $1 AND pid <> pg_backend_pid() AND state <> 'idle';`
+
+	logRows(ctx, t, db, "pg_stat_activity", activity, applicationName)
+	logRows(ctx, t, db, "pg_locks", locks, applicationName)
+	logRows(ctx, t, db, "pg_cancel_backend", cancel, applicationName)
+}
+
+// logRows logs every row of the query as a list of column=value pairs. It is
+// called from the watchdog goroutine, so errors are logged instead of
+// failing the test.
+func logRows(ctx context.Context, t TestingT, db *sql.DB, title, query string, args ...any) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		t.Logf("%s: %v", title, err)
+		return
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Logf("%s: %v", title, err)
+		return
+	}
+
+	values := make([]any, len(columns))
+	for i := range values {
+		values[i] = new(any)
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			t.Logf("%s: %v", title, err)
+			return
+		}
+
+		output := strings.Builder{}
+		output.WriteString(title + ":")
+
+		for i, column := range columns {
+			value := *values[i].(*any)
+			if bs, ok := value.([]byte); ok {
+				value = string(bs)
+			}
+
+			fmt.Fprintf(&output, " %s=%v", column, value)
+		}
+
+		t.Log(output.String())
+	}
+
+	if err := rows.Err(); err != nil {
+		t.Logf("%s: %v", title, err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// beginner is implemented by *sql.DB and *sql.Conn, which can start
+// transactions.
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type TxOption func(o *txOptions)
+
+type txOptions struct {
+	isolation   sql.IsolationLevel
+	readOnly    bool
+	maxAttempts int
+}
+
+// WithTxIsolationLevel sets the isolation level of the transactions, by
+// default the isolation level of the database is used.
+func WithTxIsolationLevel(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+// WithTxReadOnly makes the transactions read-only.
+func WithTxReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.readOnly = true
+	}
+}
+
+// WithMaxAttempts sets the maximum number of attempts to run the function of
+// WithinTx when the transaction fails with a serialization failure, by
+// default it is 3.
+func WithMaxAttempts(attempts int) TxOption {
+	return func(o *txOptions) {
+		o.maxAttempts = max(attempts, 1)
+	}
+}
+
+const defaultMaxAttempts = 3
+
+// NewTxManager returns a TxManager which starts transactions on db. When db
+// cannot start transactions, for example it is *sql.Tx or the handle of
+// testingpg.NewWithTransactionalCleanup, WithinTx uses a savepoint of the
+// transaction instead.
+func NewTxManager(db DB, opts ...TxOption) *TxManager {
+	options := txOptions{maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &TxManager{db: db, options: options}
+}
+
+// TxManager runs several calls of repositories atomically.
+type TxManager struct {
+	db      DB
+	options txOptions
+}
+
+type txKey struct{}
+
+// txFromContext returns the transaction started by WithinTx or nil.
+func txFromContext(ctx context.Context) DB {
+	db, _ := ctx.Value(txKey{}).(DB)
+	return db
+}
+
+type commitHooksKey struct{}
+
+// commitHooks are the functions run after the transaction of WithinTx is
+// committed.
+type commitHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// afterCommit runs fn after the transaction of the context is committed, fn
+// is not run if the transaction is rolled back. Without a transaction fn is
+// run immediately.
+func afterCommit(ctx context.Context, fn func()) {
+	hooks, ok := ctx.Value(commitHooksKey{}).(*commitHooks)
+	if !ok {
+		fn()
+		return
+	}
+
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+
+	hooks.fns = append(hooks.fns, fn)
+}
+
+func (h *commitHooks) run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// withTx returns the context of fn of WithinTx with the transaction and the
+// hooks run after the commit.
+func withTx(ctx context.Context, tx DB) (context.Context, *commitHooks) {
+	hooks := &commitHooks{}
+	ctx = context.WithValue(ctx, commitHooksKey{}, hooks)
+
+	return context.WithValue(ctx, txKey{}, tx), hooks
+}
+
+// WithinTx runs fn in a transaction, the transaction is stored in the context
+// passed to fn and is used by UserRepository methods called with this
+// context. The transaction is committed if fn returns nil and rolled back
+// otherwise.
+//
+// When the transaction fails with a serialization failure (SQLSTATE 40001),
+// fn is run again in a new transaction, so fn must not have side effects
+// outside the database. Calls of WithinTx inside fn join the transaction of
+// the outermost call.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	db, ok := m.db.(beginner)
+	if !ok {
+		return m.withinSavepoint(ctx, fn)
+	}
+
+	var err error
+
+	for attempt := 1; attempt <= m.options.maxAttempts; attempt++ {
+		err = m.attempt(ctx, db, fn)
+		if !isSerializationFailure(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("failed transaction after %d attempts: %w", m.options.maxAttempts, err)
+}
+
+func (m *TxManager) attempt(
+	ctx context.Context,
+	db beginner,
+	fn func(ctx context.Context) error,
+) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: m.options.isolation,
+		ReadOnly:  m.options.readOnly,
+	})
+	if err != nil {
+		return fmt.Errorf("failed start of transaction: %w", err)
+	}
+
+	txCtx, hooks := withTx(ctx, wrapTx(m.db, tx))
+
+	err = fn(txCtx)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed rollback of transaction: %w", rollbackErr))
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed commit of transaction: %w", err)
+	}
+
+	hooks.run()
+
+	return nil
+}
+
+// withinSavepoint runs fn inside the transaction which m.db already belongs
+// to. Changes of fn are rolled back to the savepoint on error. A serialization
+// failure aborts the whole transaction, so it is not retried.
+func (m *TxManager) withinSavepoint(ctx context.Context, fn func(ctx context.Context) error) error {
+	_, err := m.db.ExecContext(ctx, `SAVEPOINT within_tx;`)
+	if err != nil {
+		return fmt.Errorf("failed creation of savepoint: %w", err)
+	}
+
+	// The hooks are run after the release of the savepoint, because the
+	// transaction of m.db is not committed by TxManager.
+	txCtx, hooks := withTx(ctx, m.db)
+
+	err = fn(txCtx)
+	if err != nil {
+		_, rollbackErr := m.db.ExecContext(ctx, `ROLLBACK TO SAVEPOINT within_tx;`)
+		if rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed rollback to savepoint: %w", rollbackErr))
+		}
+
+		return err
+	}
+
+	_, err = m.db.ExecContext(ctx, `RELEASE SAVEPOINT within_tx;`)
+	if err != nil {
+		return fmt.Errorf("failed release of savepoint: %w", err)
+	}
+
+	hooks.run()
+
+	return nil
+}
+
+func isSerializationFailure(err error) bool {
+	pgErr := &pgconn.PgError{}
+
+	return errors.As(err, &pgErr) && pgErr.Code == serializationFailure
+}
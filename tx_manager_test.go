@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+func TestTxManager_WithinTx(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	errTest := errors.New("test error")
+
+	t.Run("Changes are committed if the function succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		txManager := rootpkg.NewTxManager(postgres.DB())
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.CreateUser(ctx, user1); err != nil {
+				return err
+			}
+
+			return repo.CreateUser(ctx, user2)
+		})
+
+		// Assert
+		require.NoError(t, err)
+
+		for _, user := range []rootpkg.User{user1, user2} {
+			gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+			require.NoError(t, err)
+			require.Equal(t, user, gotUser)
+		}
+	})
+
+	t.Run("Changes are rolled back if the function fails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		txManager := rootpkg.NewTxManager(postgres.DB())
+		existing := newFullyFiledUser()
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), existing))
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.CreateUser(ctx, user); err != nil {
+				return err
+			}
+
+			return errTest
+		})
+
+		// Assert
+		require.ErrorIs(t, err, errTest)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		_, err = repo.ReadUser(tenantContext(), existing.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("Function is retried on serialization failure", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		txManager := rootpkg.NewTxManager(postgres.DB())
+		user := newFullyFiledUser()
+		attempts := 0
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			attempts++
+
+			// The same user is created by every attempt, it succeeds only if
+			// changes of the failed attempt are rolled back.
+			if err := repo.CreateUser(ctx, user); err != nil {
+				return err
+			}
+
+			if attempts == 1 {
+				return &pgconn.PgError{Code: "40001"}
+			}
+
+			return nil
+		})
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 2, attempts)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Retries are limited by the maximum number of attempts", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		txManager := rootpkg.NewTxManager(postgres.DB(), rootpkg.WithMaxAttempts(2))
+		attempts := 0
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(context.Context) error {
+			attempts++
+
+			return &pgconn.PgError{Code: "40001"}
+		})
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, "40001", pgErr.Code)
+		require.Equal(t, 2, attempts)
+	})
+
+	t.Run("Nested calls join the outer transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		txManager := rootpkg.NewTxManager(postgres.DB())
+		user := newFullyFiledUser()
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			err := txManager.WithinTx(ctx, func(ctx context.Context) error {
+				return repo.CreateUser(ctx, user)
+			})
+			if err != nil {
+				return err
+			}
+
+			return errTest
+		})
+
+		// Assert
+		require.ErrorIs(t, err, errTest)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type actorKey struct{}
+
+// WithActor returns the context with the actor, for example the name of the
+// authenticated user, which is recorded in the audit of changes of users
+// made by UserRepository with this context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	return actor, ok
+}
+
+// UserAuditEntry is a change of a user recorded by the trigger of the users
+// table.
+type UserAuditEntry struct {
+	ID        int64
+	UserID    uuid.UUID
+	Operation ChangeOp
+	// OldRow is the JSON representation of the row before the change, nil for
+	// ChangeInsert.
+	OldRow json.RawMessage
+	// NewRow is the JSON representation of the row after the change, nil for
+	// ChangeDelete.
+	NewRow json.RawMessage
+	// Actor is set by WithActor, it is empty if the change was made without
+	// the actor.
+	Actor     string
+	ChangedAt time.Time
+}
+
+// ReadUserHistory returns all recorded changes of the user of the tenant in
+// the order they were made, including changes of soft-deleted and deleted
+// users.
+func (r *UserRepository) ReadUserHistory(
+	ctx context.Context,
+	userID uuid.UUID,
+) ([]UserAuditEntry, error) {
+	const sqlStr = `SELECT audit_id, user_id, operation, old_row, new_row, actor, changed_at
+FROM users_audit
+WHERE user_id = $1
+ORDER BY audit_id;`
+
+	const format = "failed selection of User history from database: %w"
+
+	var entries []UserAuditEntry
+
+	err := r.scoped(ctx, "ReadUserHistory", func(ctx context.Context) error {
+		rows, err := r.conn(ctx).QueryContext(ctx, sqlStr, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		entries, err = scanUserAuditEntries(rows)
+		if err != nil {
+			return err
+		}
+
+		recordReturnedRows(ctx, len(entries))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(format, err)
+	}
+
+	return entries, nil
+}
+
+func scanUserAuditEntries(rows *sql.Rows) ([]UserAuditEntry, error) {
+	entries := make([]UserAuditEntry, 0)
+
+	for rows.Next() {
+		entry := UserAuditEntry{}
+
+		var (
+			operation      string
+			oldRow, newRow []byte
+			actor          sql.NullString
+		)
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.UserID,
+			&operation,
+			&oldRow,
+			&newRow,
+			&actor,
+			&entry.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Operation = ChangeOp(operation)
+		entry.OldRow = oldRow
+		entry.NewRow = newRow
+		entry.Actor = actor.String
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache keeps users read by CachedUserRepository, implementations must be
+// safe for concurrent use.
+type Cache interface {
+	Get(key string) (User, bool)
+	Set(key string, user User)
+	Delete(key string)
+}
+
+// NewLRUCache returns a Cache which keeps at most capacity users, each for at
+// most ttl. The least recently used user is evicted when the cache is full.
+// Zero ttl means users do not expire.
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		capacity: max(capacity, 1),
+		ttl:      ttl,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// LRUCache is an in-memory Cache with the least recently used eviction.
+type LRUCache struct {
+	capacity int
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// order keeps entries from the most to the least recently used.
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	user      User
+	expiresAt time.Time
+}
+
+func (c *LRUCache) Get(key string) (User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return User{}, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if c.ttl > 0 && !time.Now().Before(entry.expiresAt) {
+		c.remove(element)
+		return User{}, false
+	}
+
+	c.order.MoveToFront(element)
+
+	return entry.user, true
+}
+
+func (c *LRUCache) Set(key string, user User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruEntry{key: key, user: user, expiresAt: time.Now().Add(c.ttl)}
+
+	if element, ok := c.items[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+
+		return
+	}
+
+	c.items[key] = c.order.PushFront(entry)
+
+	if c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Len returns the number of users in the cache, including expired ones which
+// have not been evicted yet.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*lruEntry).key)
+}
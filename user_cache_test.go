@@ -0,0 +1,121 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+)
+
+func TestLRUCache(t *testing.T) {
+	t.Parallel()
+
+	newUser := func() rootpkg.User {
+		return rootpkg.User{ID: uuid.New(), Username: "gopher"}
+	}
+
+	t.Run("User is found after it is set", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		cache := rootpkg.NewLRUCache(10, time.Minute)
+		user := newUser()
+
+		cache.Set("key", user)
+
+		// Act
+		gotUser, ok := cache.Get("key")
+
+		// Assert
+		require.True(t, ok)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("User is not found after it is deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		cache := rootpkg.NewLRUCache(10, time.Minute)
+
+		cache.Set("key", newUser())
+		cache.Delete("key")
+
+		// Act
+		_, ok := cache.Get("key")
+
+		// Assert
+		require.False(t, ok)
+		require.Zero(t, cache.Len())
+	})
+
+	t.Run("Least recently used user is evicted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		cache := rootpkg.NewLRUCache(2, time.Minute)
+
+		cache.Set("first", newUser())
+		cache.Set("second", newUser())
+
+		_, ok := cache.Get("first")
+		require.True(t, ok)
+
+		// Act
+		cache.Set("third", newUser())
+
+		// Assert
+		require.Equal(t, 2, cache.Len())
+
+		_, ok = cache.Get("second")
+		require.False(t, ok, "the least recently used user must be evicted")
+
+		_, ok = cache.Get("first")
+		require.True(t, ok)
+
+		_, ok = cache.Get("third")
+		require.True(t, ok)
+	})
+
+	t.Run("Setting of the same key replaces the user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		cache := rootpkg.NewLRUCache(10, time.Minute)
+		user := newUser()
+
+		cache.Set("key", newUser())
+
+		// Act
+		cache.Set("key", user)
+
+		// Assert
+		gotUser, ok := cache.Get("key")
+		require.True(t, ok)
+		require.Equal(t, user, gotUser)
+		require.Equal(t, 1, cache.Len())
+	})
+
+	t.Run("User expires after TTL", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		cache := rootpkg.NewLRUCache(10, 10*time.Millisecond)
+
+		cache.Set("key", newUser())
+
+		// Act
+		time.Sleep(20 * time.Millisecond)
+
+		// Assert
+		_, ok := cache.Get("key")
+		require.False(t, ok)
+		require.Zero(t, cache.Len(), "the expired user must be evicted")
+	})
+}
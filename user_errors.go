@@ -0,0 +1,54 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrUserIDConflict  = errors.New("user with the same ID already exists")
+	ErrInvalidUsername = errors.New("username is invalid")
+	ErrInvalidCursor   = errors.New("cursor is invalid")
+	ErrTenantRequired  = errors.New("tenant is required")
+
+	ErrConcurrentModification = errors.New("user has been modified concurrently")
+)
+
+// SQLSTATE codes of errors handled by the package.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolation      = "23505"
+	checkViolation       = "23514"
+	serializationFailure = "40001"
+)
+
+// constraintErrors maps the constraints of the users table to the errors
+// returned when they are violated.
+var constraintErrors = map[string]error{
+	"users_pkey":               ErrUserIDConflict,
+	"users_username_lower_key": ErrUsernameTaken,
+	"users_username_check":     ErrInvalidUsername,
+}
+
+// translateError returns the domain error corresponding to the Postgres error
+// or nil if there is no such error.
+func translateError(err error) error {
+	pgErr := &pgconn.PgError{}
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	switch pgErr.Code {
+	case uniqueViolation, checkViolation:
+		return constraintErrors[pgErr.ConstraintName]
+	default:
+		return nil
+	}
+}
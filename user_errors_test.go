@@ -0,0 +1,298 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+)
+
+// execErrorDB is a DB whose ExecContext fails with err for statements on the
+// users table, statements of savepoints and of the tenant succeed. Without err
+// statements on the users table affect no rows.
+type execErrorDB struct {
+	rootpkg.DB
+
+	err error
+}
+
+func (e execErrorDB) ExecContext(_ context.Context, query string, _ ...any) (sql.Result, error) {
+	if !strings.Contains(query, "users") {
+		return driver.RowsAffected(0), nil
+	}
+
+	if e.err == nil {
+		return driver.RowsAffected(0), nil
+	}
+
+	return nil, e.err
+}
+
+func TestUserRepository_CreateUser_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{
+			name:    "Case-insensitive username unique violation is ErrUsernameTaken",
+			err:     &pgconn.PgError{Code: "23505", ConstraintName: "users_username_lower_key"},
+			wantErr: rootpkg.ErrUsernameTaken,
+		},
+		{
+			name:    "Primary key unique violation is ErrUserIDConflict",
+			err:     &pgconn.PgError{Code: "23505", ConstraintName: "users_pkey"},
+			wantErr: rootpkg.ErrUserIDConflict,
+		},
+		{
+			name:    "Username check violation is ErrInvalidUsername",
+			err:     &pgconn.PgError{Code: "23514", ConstraintName: "users_username_check"},
+			wantErr: rootpkg.ErrInvalidUsername,
+		},
+		{
+			name: "Unknown unique violation is not translated",
+			err:  &pgconn.PgError{Code: "23505", ConstraintName: "unknown"},
+		},
+		{
+			name: "Other Postgres errors are not translated",
+			err:  &pgconn.PgError{Code: "42501"},
+		},
+		{
+			name: "Other errors are not translated",
+			err:  errors.New("connection refused"),
+		},
+	}
+
+	domainErrors := []error{
+		rootpkg.ErrUserNotFound,
+		rootpkg.ErrUsernameTaken,
+		rootpkg.ErrUserIDConflict,
+		rootpkg.ErrInvalidUsername,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			repo := rootpkg.NewUserRepository(execErrorDB{err: tt.err})
+
+			// Act
+			err := repo.CreateUser(tenantContext(), rootpkg.User{ID: uuid.New()})
+
+			// Assert
+			require.ErrorIs(t, err, tt.err, "the original error must be preserved")
+
+			for _, domainErr := range domainErrors {
+				if domainErr == tt.wantErr {
+					require.ErrorIs(t, err, domainErr)
+				} else {
+					require.NotErrorIs(t, err, domainErr)
+				}
+			}
+		})
+	}
+}
+
+func TestUserRepository_ListUsers_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		page    rootpkg.Page
+		wantErr error
+	}{
+		{
+			name:    "Cursor is not base64",
+			page:    rootpkg.Page{Cursor: "not a cursor"},
+			wantErr: rootpkg.ErrInvalidCursor,
+		},
+		{
+			name:    "Cursor is not JSON",
+			page:    rootpkg.Page{Cursor: "bm90IGpzb24"},
+			wantErr: rootpkg.ErrInvalidCursor,
+		},
+		{
+			name: "Page size is negative",
+			page: rootpkg.Page{Size: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			repo := rootpkg.NewUserRepository(execErrorDB{})
+
+			// Act
+			users, next, err := repo.ListUsers(tenantContext(), rootpkg.UserFilter{}, tt.page)
+
+			// Assert
+			require.Error(t, err)
+			require.Empty(t, users)
+			require.Empty(t, next)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserRepository_CreateUsers_Errors(t *testing.T) {
+	t.Parallel()
+
+	users := []rootpkg.User{
+		{ID: uuid.New(), Username: "gopher"},
+		{ID: uuid.New(), Username: "Gopher"},
+		{ID: uuid.New(), Username: "go"},
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		wantErr   error
+		wantIndex int
+	}{
+		{
+			name: "Index is found by the ID in the detail",
+			err: &pgconn.PgError{
+				Code:           "23505",
+				ConstraintName: "users_pkey",
+				Detail:         "Key (user_id)=(" + users[0].ID.String() + ") already exists.",
+			},
+			wantErr:   rootpkg.ErrUserIDConflict,
+			wantIndex: 0,
+		},
+		{
+			name: "Index of the last user with the username in the detail is found",
+			err: &pgconn.PgError{
+				Code:           "23505",
+				ConstraintName: "users_username_lower_key",
+				Detail:         "Key (lower(username::text))=(gopher) already exists.",
+			},
+			wantErr:   rootpkg.ErrUsernameTaken,
+			wantIndex: 1,
+		},
+		{
+			name: "Index of the username in the detail of the key of the tenant is found",
+			err: &pgconn.PgError{
+				Code:           "23505",
+				ConstraintName: "users_username_lower_key",
+				Detail: "Key (tenant_id, lower(username::text))=(" + uuid.Nil.String() +
+					", gopher) already exists.",
+			},
+			wantErr:   rootpkg.ErrUsernameTaken,
+			wantIndex: 1,
+		},
+		{
+			name: "Index is found by the failing row in the detail",
+			err: &pgconn.PgError{
+				Code:           "23514",
+				ConstraintName: "users_username_check",
+				Detail:         "Failing row contains (" + users[2].ID.String() + ", go, 2024).",
+			},
+			wantErr:   rootpkg.ErrInvalidUsername,
+			wantIndex: 2,
+		},
+		{
+			name: "Index is unknown without the detail",
+			err: &pgconn.PgError{
+				Code:           "23505",
+				ConstraintName: "users_pkey",
+			},
+			wantErr:   rootpkg.ErrUserIDConflict,
+			wantIndex: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			repo := rootpkg.NewUserRepository(execErrorDB{err: tt.err})
+
+			// Act
+			err := repo.CreateUsers(tenantContext(), users)
+
+			// Assert
+			batchErr := &rootpkg.BatchError{}
+			require.ErrorAs(t, err, &batchErr)
+			require.Equal(t, tt.wantIndex, batchErr.Index)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	t.Run("Other errors are not batch errors", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo := rootpkg.NewUserRepository(execErrorDB{err: errors.New("connection refused")})
+
+		// Act
+		err := repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		require.Error(t, err)
+
+		batchErr := &rootpkg.BatchError{}
+		require.NotErrorAs(t, err, &batchErr)
+	})
+}
+
+func TestUserRepository_TenantRequired(t *testing.T) {
+	t.Parallel()
+
+	calls := map[string]func(repo *rootpkg.UserRepository) error{
+		"ReadUser": func(repo *rootpkg.UserRepository) error {
+			_, err := repo.ReadUser(context.Background(), uuid.New())
+			return err
+		},
+		"CreateUser": func(repo *rootpkg.UserRepository) error {
+			return repo.CreateUser(context.Background(), rootpkg.User{ID: uuid.New()})
+		},
+		"CreateUsers": func(repo *rootpkg.UserRepository) error {
+			return repo.CreateUsers(context.Background(), []rootpkg.User{{ID: uuid.New()}})
+		},
+		"ListUsers": func(repo *rootpkg.UserRepository) error {
+			_, _, err := repo.ListUsers(context.Background(), rootpkg.UserFilter{}, rootpkg.Page{})
+			return err
+		},
+		"SearchUsers": func(repo *rootpkg.UserRepository) error {
+			_, err := repo.SearchUsers(context.Background(), "gopher", 10)
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// Arrange
+			repo := rootpkg.NewUserRepository(execErrorDB{err: errors.New("unexpected statement")})
+
+			// Act
+			err := call(repo)
+
+			// Assert
+			require.ErrorIs(t, err, rootpkg.ErrTenantRequired)
+		})
+	}
+}
@@ -10,8 +10,15 @@ import (
 	"github.com/google/uuid"
 )
 
+// User is a row of the users table, the db tags describe its columns, so that
+// drift between the model and the migrations is detected by tests.
 type User struct {
-	ID        uuid.UUID
-	Username  string
-	CreatedAt time.Time
+	ID        uuid.UUID `db:"user_id"`
+	Username  string    `db:"username"`
+	CreatedAt time.Time `db:"created_at"`
+	// Version is incremented by every change of the user, it is used to
+	// detect concurrent modifications.
+	Version int64 `db:"version"`
+	// DeletedAt is the time of the soft deletion, nil for active users.
+	DeletedAt *time.Time `db:"deleted_at"`
 }
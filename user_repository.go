@@ -7,12 +7,18 @@ package testing_go_code_with_postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type DB interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
@@ -21,18 +27,97 @@ func NewUserRepository(db DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// UserRepository sends its queries as text, they are prepared and cached per
+// connection by pgx, see pgx.QueryExecModeCacheStatement.
 type UserRepository struct {
 	db DB
 }
 
-func (r *UserRepository) ReadUser(ctx context.Context, userID uuid.UUID) (User, error) {
-	const sql = `SELECT user_id, username, created_at FROM users WHERE user_id = $1;`
+// conn returns the transaction of TxManager.WithinTx stored in the context or
+// the database of the repository.
+func (r *UserRepository) conn(ctx context.Context) DB {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx
+	}
+
+	return r.db
+}
+
+// ReadOption changes the behavior of ReadUser and ReadUserByUsername.
+type ReadOption func(o *readOptions)
+
+type readOptions struct {
+	withDeleted bool
+}
+
+// WithDeleted makes soft-deleted users visible, by default they are not found.
+func WithDeleted() ReadOption {
+	return func(o *readOptions) {
+		o.withDeleted = true
+	}
+}
+
+func (r *UserRepository) ReadUser(
+	ctx context.Context,
+	userID uuid.UUID,
+	opts ...ReadOption,
+) (User, error) {
+	const sqlStr = `SELECT user_id, username, created_at, version, deleted_at FROM users
+WHERE user_id = $1 AND ($2 OR deleted_at IS NULL);`
+
+	return r.readUser(ctx, "ReadUser", sqlStr, userID, opts)
+}
+
+// ReadUserByUsername reads the user by username, the username is compared
+// case-insensitively as it is unique regardless of case. With WithDeleted
+// the active user is preferred over soft-deleted ones, which can share the
+// username, and then the most recently deleted one is returned.
+func (r *UserRepository) ReadUserByUsername(
+	ctx context.Context,
+	username string,
+	opts ...ReadOption,
+) (User, error) {
+	const sqlStr = `SELECT user_id, username, created_at, version, deleted_at FROM users
+WHERE lower(username) = lower($1) AND ($2 OR deleted_at IS NULL)
+ORDER BY deleted_at DESC NULLS FIRST
+LIMIT 1;`
+
+	return r.readUser(ctx, "ReadUserByUsername", sqlStr, username, opts)
+}
+
+func (r *UserRepository) readUser(
+	ctx context.Context,
+	method string,
+	sqlStr string,
+	key any,
+	opts []ReadOption,
+) (User, error) {
+	options := readOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
 
 	user := User{}
 
-	row := r.db.QueryRowContext(ctx, sql, userID)
+	err := r.scoped(ctx, method, func(ctx context.Context) error {
+		row := r.conn(ctx).QueryRowContext(ctx, sqlStr, key, options.withDeleted)
+
+		err := row.Scan(&user.ID, &user.Username, &user.CreatedAt, &user.Version, &user.DeletedAt)
+
+		switch {
+		case err == nil:
+			recordReturnedRows(ctx, 1)
+		case errors.Is(err, sql.ErrNoRows):
+			recordReturnedRows(ctx, 0)
+		}
+
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		const format = "failed selection of User from database: %w: %w"
+		return User{}, fmt.Errorf(format, ErrUserNotFound, err)
+	}
 
-	err := row.Scan(&user.ID, &user.Username, &user.CreatedAt)
 	if err != nil {
 		const format = "failed selection of User from database: %w"
 		return User{}, fmt.Errorf(format, err)
@@ -42,18 +127,429 @@ func (r *UserRepository) ReadUser(ctx context.Context, userID uuid.UUID) (User,
 }
 
 func (r *UserRepository) CreateUser(ctx context.Context, user User) error {
-	const sql = `INSERT INTO users (user_id, username, created_at) VALUES ($1,$2,$3);`
-
-	_, err := r.db.ExecContext(
-		ctx,
-		sql,
-		user.ID,
-		user.Username,
-		user.CreatedAt,
-	)
-	if err != nil {
-		const format = "failed insertion of User to database: %v"
-		return fmt.Errorf(format, err)
+	return r.scoped(ctx, "CreateUser", func(ctx context.Context) error {
+		const sqlStr = `INSERT INTO users (user_id, username, created_at, version)
+VALUES ($1,$2,$3,$4);`
+
+		_, err := r.conn(ctx).ExecContext(
+			ctx,
+			sqlStr,
+			user.ID,
+			user.Username,
+			user.CreatedAt,
+			user.Version,
+		)
+		if domainErr := translateError(err); domainErr != nil {
+			const format = "failed insertion of User to database: %w: %w"
+			return fmt.Errorf(format, domainErr, err)
+		}
+
+		if err != nil {
+			const format = "failed insertion of User to database: %w"
+			return fmt.Errorf(format, err)
+		}
+
+		return nil
+	})
+}
+
+// UserField is a field of User which can be changed by UpdateUser.
+type UserField string
+
+const (
+	UserFieldUsername  UserField = "username"
+	UserFieldCreatedAt UserField = "created_at"
+)
+
+// userFieldValues returns values of the fields of User, the keys are also
+// the names of the columns of the users table.
+var userFieldValues = map[UserField]func(user User) any{
+	UserFieldUsername:  func(user User) any { return user.Username },
+	UserFieldCreatedAt: func(user User) any { return user.CreatedAt },
+}
+
+// UpdateUser updates the fields of the user listed in the mask, the other
+// fields are left untouched. An empty mask updates all fields. Soft-deleted
+// users cannot be updated until they are restored.
+//
+// The user is updated only if its version in the database is equal to
+// user.Version, otherwise ErrConcurrentModification is returned. The version
+// is incremented by the update, so the user has to be read again before the
+// next update.
+func (r *UserRepository) UpdateUser(ctx context.Context, user User, mask ...UserField) error {
+	return r.scoped(ctx, "UpdateUser", func(ctx context.Context) error {
+		const message = "failed update of User in database"
+
+		if len(mask) == 0 {
+			mask = []UserField{UserFieldUsername, UserFieldCreatedAt}
+		}
+
+		sets := make([]string, 0, len(mask))
+		args := []any{user.ID, user.Version}
+
+		for _, field := range mask {
+			value, ok := userFieldValues[field]
+			if !ok {
+				return fmt.Errorf("%s: unknown field %q", message, field)
+			}
+
+			args = append(args, value(user))
+			sets = append(sets, fmt.Sprintf("%s = $%d", field, len(args)))
+		}
+
+		sqlStr := fmt.Sprintf(
+			`UPDATE users SET %s, version = version + 1
+WHERE user_id = $1 AND version = $2 AND deleted_at IS NULL;`,
+			strings.Join(sets, ", "),
+		)
+
+		result, err := r.conn(ctx).ExecContext(ctx, sqlStr, args...)
+
+		err = checkAffected(message, result, err)
+		if !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+
+		// No rows were affected, either the user does not exist or its version
+		// has been changed by someone else.
+		exists, existsErr := r.userExists(ctx, user)
+		if existsErr != nil {
+			return fmt.Errorf("%s: %w", message, existsErr)
+		}
+
+		if exists {
+			return fmt.Errorf("%s: %w", message, ErrConcurrentModification)
+		}
+
+		return err
+	})
+}
+
+func (r *UserRepository) userExists(ctx context.Context, user User) (bool, error) {
+	const sqlStr = `SELECT EXISTS (SELECT FROM users WHERE user_id = $1 AND deleted_at IS NULL);`
+
+	exists := false
+
+	err := r.conn(ctx).QueryRowContext(ctx, sqlStr, user.ID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// DeleteUser deletes the user permanently, including a soft-deleted one.
+func (r *UserRepository) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	return r.scoped(ctx, "DeleteUser", func(ctx context.Context) error {
+		const sqlStr = `DELETE FROM users WHERE user_id = $1;`
+
+		result, err := r.conn(ctx).ExecContext(ctx, sqlStr, userID)
+
+		return checkAffected("failed deletion of User from database", result, err)
+	})
+}
+
+// SoftDeleteUser marks the active user as deleted, the user is hidden from
+// reads and its username can be taken by another user, but the user is kept
+// until it is purged by PurgeDeletedUsers.
+func (r *UserRepository) SoftDeleteUser(ctx context.Context, userID uuid.UUID) error {
+	return r.scoped(ctx, "SoftDeleteUser", func(ctx context.Context) error {
+		const sqlStr = `UPDATE users SET deleted_at = now(), version = version + 1
+WHERE user_id = $1 AND deleted_at IS NULL;`
+
+		result, err := r.conn(ctx).ExecContext(ctx, sqlStr, userID)
+
+		return checkAffected("failed soft deletion of User in database", result, err)
+	})
+}
+
+// RestoreUser makes the soft-deleted user active again, ErrUsernameTaken is
+// returned if its username has been taken since the deletion.
+func (r *UserRepository) RestoreUser(ctx context.Context, userID uuid.UUID) error {
+	return r.scoped(ctx, "RestoreUser", func(ctx context.Context) error {
+		const sqlStr = `UPDATE users SET deleted_at = NULL, version = version + 1
+WHERE user_id = $1 AND deleted_at IS NOT NULL;`
+
+		result, err := r.conn(ctx).ExecContext(ctx, sqlStr, userID)
+
+		return checkAffected("failed restoration of User in database", result, err)
+	})
+}
+
+// PurgeDeletedUsers permanently deletes users which were soft-deleted at
+// least olderThan ago by the clock of the database and returns the number of
+// the purged users.
+func (r *UserRepository) PurgeDeletedUsers(
+	ctx context.Context,
+	olderThan time.Duration,
+) (int64, error) {
+	const sqlStr = `DELETE FROM users WHERE deleted_at <= now() - make_interval(secs => $1);`
+
+	const format = "failed purge of deleted Users from database: %w"
+
+	var purged int64
+
+	err := r.scoped(ctx, "PurgeDeletedUsers", func(ctx context.Context) error {
+		result, err := r.conn(ctx).ExecContext(ctx, sqlStr, olderThan.Seconds())
+		if err != nil {
+			return err
+		}
+
+		purged, err = result.RowsAffected()
+
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf(format, err)
+	}
+
+	return purged, nil
+}
+
+// UpsertUser creates the user or replaces all fields of the existing user
+// with the same ID regardless of its version, the version of the existing
+// user is incremented. A soft-deleted user is restored.
+func (r *UserRepository) UpsertUser(ctx context.Context, user User) error {
+	return r.scoped(ctx, "UpsertUser", func(ctx context.Context) error {
+		const sqlStr = `INSERT INTO users (user_id, username, created_at, version)
+VALUES ($1,$2,$3,$4)
+ON CONFLICT (user_id) DO UPDATE SET username   = excluded.username,
+                                    created_at = excluded.created_at,
+                                    version    = users.version + 1,
+                                    deleted_at = NULL;`
+
+		_, err := r.conn(ctx).ExecContext(
+			ctx,
+			sqlStr,
+			user.ID,
+			user.Username,
+			user.CreatedAt,
+			user.Version,
+		)
+		if domainErr := translateError(err); domainErr != nil {
+			const format = "failed upsert of User to database: %w: %w"
+			return fmt.Errorf(format, domainErr, err)
+		}
+
+		if err != nil {
+			const format = "failed upsert of User to database: %w"
+			return fmt.Errorf(format, err)
+		}
+
+		return nil
+	})
+}
+
+// UserFilter limits the users returned by ListUsers, zero fields are ignored.
+type UserFilter struct {
+	// UsernamePrefix is compared case-insensitively.
+	UsernamePrefix string
+	// CreatedFrom is the inclusive lower bound of CreatedAt.
+	CreatedFrom time.Time
+	// CreatedTo is the exclusive upper bound of CreatedAt.
+	CreatedTo time.Time
+	// IncludeDeleted makes soft-deleted users listed along with active ones.
+	IncludeDeleted bool
+}
+
+const defaultPageSize = 50
+
+// Page selects a page of ListUsers results.
+type Page struct {
+	// Size is the maximum number of users in the page, by default it is 50.
+	Size int
+	// Cursor is the opaque token returned by the previous call of ListUsers,
+	// an empty cursor selects the first page.
+	Cursor string
+}
+
+// userCursor is the position of the last user in the page, users are ordered
+// by the creation time and then by the ID.
+type userCursor struct {
+	CreatedAt int64     `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+// ListUsers returns users matching the filter ordered by the creation time
+// and the ID, and the cursor of the next page or an empty string if it is
+// the last page. Pages are selected by the keyset of the last user rather
+// than OFFSET, so their cost does not depend on the position of the page.
+func (r *UserRepository) ListUsers(
+	ctx context.Context,
+	filter UserFilter,
+	page Page,
+) ([]User, string, error) {
+	const sqlStr = `SELECT user_id, username, created_at, version, deleted_at FROM users
+WHERE ($1 = '' OR lower(username) LIKE lower($1) || '%')
+  AND ($2::timestamptz IS NULL OR created_at >= $2)
+  AND ($3::timestamptz IS NULL OR created_at < $3)
+  AND ($4::timestamptz IS NULL OR (created_at, user_id) > ($4, $5))
+  AND ($6 OR deleted_at IS NULL)
+ORDER BY created_at, user_id
+LIMIT $7;`
+
+	const format = "failed listing of Users from database: %w"
+
+	if page.Size < 0 {
+		return nil, "", fmt.Errorf(format, fmt.Errorf("negative page size %d", page.Size))
+	}
+
+	if page.Size == 0 {
+		page.Size = defaultPageSize
+	}
+
+	cursor, err := decodeUserCursor(page.Cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf(format, err)
+	}
+
+	var afterCreatedAt *time.Time
+	if cursor != nil {
+		createdAt := time.UnixMicro(cursor.CreatedAt)
+		afterCreatedAt = &createdAt
+	}
+
+	var users []User
+
+	err = r.scoped(ctx, "ListUsers", func(ctx context.Context) error {
+		// One more user is requested to find out if there is the next page.
+		rows, err := r.conn(ctx).QueryContext(
+			ctx,
+			sqlStr,
+			escapeLike(filter.UsernamePrefix),
+			nullTime(filter.CreatedFrom),
+			nullTime(filter.CreatedTo),
+			afterCreatedAt,
+			userIDOf(cursor),
+			filter.IncludeDeleted,
+			page.Size+1,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		users, err = scanUsers(rows, page.Size+1)
+		if err != nil {
+			return err
+		}
+
+		recordReturnedRows(ctx, len(users))
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf(format, err)
+	}
+
+	if len(users) <= page.Size {
+		return users, "", nil
+	}
+
+	users = users[:page.Size]
+
+	return users, encodeUserCursor(users[len(users)-1]), nil
+}
+
+// scanUsers scans all rows of the selection of the columns of User, size is
+// the expected number of the rows.
+func scanUsers(rows *sql.Rows, size int) ([]User, error) {
+	users := make([]User, 0, size)
+
+	for rows.Next() {
+		user := User{}
+
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.CreatedAt,
+			&user.Version,
+			&user.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func encodeUserCursor(last User) string {
+	// Marshaling of the struct with simple fields cannot fail.
+	data, _ := json.Marshal(userCursor{
+		CreatedAt: last.CreatedAt.UnixMicro(),
+		UserID:    last.ID,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(token string) (*userCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	cursor := &userCursor{}
+
+	err = json.Unmarshal(data, cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	return cursor, nil
+}
+
+func userIDOf(cursor *userCursor) *uuid.UUID {
+	if cursor == nil {
+		return nil
+	}
+
+	return &cursor.UserID
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// escapeLike escapes the wildcards of the LIKE pattern, so that the value is
+// matched literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// checkAffected translates the error of the statement which changes a single
+// user and returns ErrUserNotFound if no rows were affected.
+func checkAffected(message string, result sql.Result, err error) error {
+	if domainErr := translateError(err); domainErr != nil {
+		return fmt.Errorf("%s: %w: %w", message, domainErr, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", message, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", message, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", message, ErrUserNotFound)
 	}
 
 	return nil
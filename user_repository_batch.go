@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// BatchError is returned by CreateUsers when a user of the batch cannot be
+// created, none of the users of the batch are created in this case.
+type BatchError struct {
+	// Index of the user in the batch, or -1 if it cannot be determined.
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("user with index %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// CreateUsers creates all users of the batch or none of them by a single
+// multi-row INSERT. The COPY protocol is not used, because Postgres does not
+// support COPY FROM to tables with row-level security for roles which are
+// subject to it, like the role of the application.
+func (r *UserRepository) CreateUsers(ctx context.Context, users []User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	err := r.scoped(ctx, "CreateUsers", func(ctx context.Context) error {
+		return r.insertUsers(ctx, users)
+	})
+	if err == nil {
+		return nil
+	}
+
+	const format = "failed batch insertion of Users to database: %w"
+
+	if domainErr := translateError(err); domainErr != nil {
+		return fmt.Errorf(format, &BatchError{
+			Index: conflictingUserIndex(err, users),
+			Err:   fmt.Errorf("%w: %w", domainErr, err),
+		})
+	}
+
+	return fmt.Errorf(format, err)
+}
+
+func (r *UserRepository) insertUsers(ctx context.Context, users []User) error {
+	// The arrays are used instead of a placeholder per value, so that the
+	// size of the batch is not limited by the maximum number of parameters.
+	const sqlStr = `INSERT INTO users (user_id, username, created_at, version)
+SELECT * FROM unnest($1::uuid[], $2::text[], $3::timestamptz[], $4::bigint[]);`
+
+	ids := make([]string, len(users))
+	usernames := make([]string, len(users))
+	createdAts := make([]time.Time, len(users))
+	versions := make([]int64, len(users))
+
+	for i, user := range users {
+		ids[i] = user.ID.String()
+		usernames[i] = user.Username
+		createdAts[i] = user.CreatedAt
+		versions[i] = user.Version
+	}
+
+	_, err := r.conn(ctx).ExecContext(ctx, sqlStr, ids, usernames, createdAts, versions)
+
+	return err
+}
+
+var (
+	// keyDetailRe matches the detail of unique violations, like "Key
+	// (user_id)=(...) already exists.".
+	keyDetailRe = regexp.MustCompile(`^Key \((.+)\)=\((.*)\) already exists\.$`)
+	// rowDetailRe matches the detail of check violations, like "Failing row
+	// contains (user_id, ...).".
+	rowDetailRe = regexp.MustCompile(`^Failing row contains \(([^,]+),`)
+)
+
+// conflictingUserIndex finds the index of the user which caused the error by
+// the detail of the Postgres error. If several users match the detail, the
+// last of them is reported, because earlier users are conflicting only with
+// later ones within the batch.
+func conflictingUserIndex(err error, users []User) int {
+	pgErr := &pgconn.PgError{}
+	if !errors.As(err, &pgErr) {
+		return -1
+	}
+
+	matches := func(User) bool { return false }
+
+	if match := keyDetailRe.FindStringSubmatch(pgErr.Detail); match != nil {
+		switch column, value := match[1], match[2]; {
+		case column == "user_id":
+			matches = func(user User) bool { return user.ID.String() == value }
+		case strings.Contains(column, "username"):
+			// The username is the last column of the key, which is unique per
+			// tenant, and it cannot contain commas.
+			if i := strings.LastIndex(value, ", "); i >= 0 {
+				value = value[i+len(", "):]
+			}
+
+			matches = func(user User) bool { return strings.EqualFold(user.Username, value) }
+		}
+	} else if match := rowDetailRe.FindStringSubmatch(pgErr.Detail); match != nil {
+		matches = func(user User) bool { return user.ID.String() == match[1] }
+	}
+
+	for i := len(users) - 1; i >= 0; i-- {
+		if matches(users[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+// NewCachedUserRepository returns a repository which caches users read by
+// ReadUser of repo in cache.
+func NewCachedUserRepository(repo *UserRepository, cache Cache) *CachedUserRepository {
+	return &CachedUserRepository{UserRepository: repo, cache: cache}
+}
+
+// CachedUserRepository caches active users read by ReadUser without options
+// and invalidates them when they are changed through it. Concurrent reads of
+// the same missing user are merged into a single query.
+//
+// Reads in a transaction of TxManager.WithinTx bypass the cache, because they
+// may see uncommitted changes. Changes in a transaction invalidate the users
+// after the commit, so the state read before the commit is not kept, and the
+// users are not invalidated on rollback. A read in flight when the user is
+// invalidated does not cache its result, because it may be the previous
+// state. Changes made by other instances or directly in the database are
+// visible only after the user expires. PurgeDeletedUsers does not touch the
+// cache, because soft-deleted users are not cached.
+type CachedUserRepository struct {
+	*UserRepository
+
+	cache Cache
+	group singleflight.Group
+
+	mu sync.Mutex
+	// reads are the reads in flight by the keys of the users.
+	reads map[string]*cachedRead
+}
+
+// cachedRead is a read in flight, it is stale if the user was invalidated
+// while it was running.
+type cachedRead struct {
+	stale bool
+}
+
+// cacheKey returns the key of the user in the cache, users are cached per
+// tenant, because the same ID is not visible to other tenants.
+func cacheKey(ctx context.Context, userID uuid.UUID) (string, bool) {
+	tenantID, ok := tenantFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	return tenantID.String() + "/" + userID.String(), true
+}
+
+func (r *CachedUserRepository) ReadUser(
+	ctx context.Context,
+	userID uuid.UUID,
+	opts ...ReadOption,
+) (User, error) {
+	key, ok := cacheKey(ctx, userID)
+	if !ok || len(opts) > 0 || txFromContext(ctx) != nil {
+		return r.UserRepository.ReadUser(ctx, userID, opts...)
+	}
+
+	if user, ok := r.cache.Get(key); ok {
+		return user, nil
+	}
+
+	// The query is shared by all callers waiting for it, so it is not
+	// canceled when the context of one of them is done.
+	results := r.group.DoChan(key, func() (any, error) {
+		read := r.startRead(key)
+
+		user, err := r.UserRepository.ReadUser(context.WithoutCancel(ctx), userID)
+
+		r.completeRead(key, read, user, err)
+
+		return user, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return User{}, ctx.Err()
+	case result := <-results:
+		return result.Val.(User), result.Err
+	}
+}
+
+// startRead registers the read of the user in flight.
+func (r *CachedUserRepository) startRead(key string) *cachedRead {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reads == nil {
+		r.reads = make(map[string]*cachedRead)
+	}
+
+	read := &cachedRead{}
+	r.reads[key] = read
+
+	return read
+}
+
+// completeRead caches the user read successfully, unless the user was
+// invalidated while it was read.
+func (r *CachedUserRepository) completeRead(key string, read *cachedRead, user User, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reads[key] == read {
+		delete(r.reads, key)
+	}
+
+	if err == nil && !read.stale {
+		r.cache.Set(key, user)
+	}
+}
+
+func (r *CachedUserRepository) CreateUser(ctx context.Context, user User) error {
+	defer r.invalidate(ctx, user.ID)
+
+	return r.UserRepository.CreateUser(ctx, user)
+}
+
+func (r *CachedUserRepository) CreateUsers(ctx context.Context, users []User) error {
+	defer func() {
+		for _, user := range users {
+			r.invalidate(ctx, user.ID)
+		}
+	}()
+
+	return r.UserRepository.CreateUsers(ctx, users)
+}
+
+func (r *CachedUserRepository) UpdateUser(ctx context.Context, user User, mask ...UserField) error {
+	defer r.invalidate(ctx, user.ID)
+
+	return r.UserRepository.UpdateUser(ctx, user, mask...)
+}
+
+func (r *CachedUserRepository) UpsertUser(ctx context.Context, user User) error {
+	defer r.invalidate(ctx, user.ID)
+
+	return r.UserRepository.UpsertUser(ctx, user)
+}
+
+func (r *CachedUserRepository) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	defer r.invalidate(ctx, userID)
+
+	return r.UserRepository.DeleteUser(ctx, userID)
+}
+
+func (r *CachedUserRepository) SoftDeleteUser(ctx context.Context, userID uuid.UUID) error {
+	defer r.invalidate(ctx, userID)
+
+	return r.UserRepository.SoftDeleteUser(ctx, userID)
+}
+
+func (r *CachedUserRepository) RestoreUser(ctx context.Context, userID uuid.UUID) error {
+	defer r.invalidate(ctx, userID)
+
+	return r.UserRepository.RestoreUser(ctx, userID)
+}
+
+// invalidate removes the user from the cache after the commit of the
+// transaction of the context, or immediately without a transaction. The read
+// in flight is marked stale and forgotten, so that neither it nor the reads
+// started after the change cache the previous state.
+func (r *CachedUserRepository) invalidate(ctx context.Context, userID uuid.UUID) {
+	key, ok := cacheKey(ctx, userID)
+	if !ok {
+		return
+	}
+
+	afterCommit(ctx, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if read, ok := r.reads[key]; ok {
+			read.stale = true
+			delete(r.reads, key)
+		}
+
+		r.group.Forget(key)
+		r.cache.Delete(key)
+	})
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+// countingDB counts the statements and the transactions started on the
+// database, statements inside the transactions are not counted.
+type countingDB struct {
+	*sql.DB
+
+	calls atomic.Int64
+}
+
+func (c *countingDB) QueryContext(
+	ctx context.Context,
+	query string,
+	args ...any,
+) (*sql.Rows, error) {
+	c.calls.Add(1)
+	return c.DB.QueryContext(ctx, query, args...)
+}
+
+func (c *countingDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	c.calls.Add(1)
+	return c.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (c *countingDB) ExecContext(
+	ctx context.Context,
+	query string,
+	args ...any,
+) (sql.Result, error) {
+	c.calls.Add(1)
+	return c.DB.ExecContext(ctx, query, args...)
+}
+
+func (c *countingDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	c.calls.Add(1)
+	return c.DB.BeginTx(ctx, opts)
+}
+
+// pausingHandler is a slog.Handler of LoggingDB which pauses the call after
+// the first statement selecting users once it is armed, the statement has
+// already been run, so its result is the state before the pause.
+type pausingHandler struct {
+	armed   atomic.Bool
+	paused  chan struct{}
+	release chan struct{}
+}
+
+func newPausingHandler() *pausingHandler {
+	return &pausingHandler{paused: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (h *pausingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *pausingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *pausingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *pausingHandler) Handle(_ context.Context, record slog.Record) error {
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key != "sql" || !strings.HasPrefix(attr.Value.String(), "SELECT user_id") {
+			return true
+		}
+
+		if h.armed.CompareAndSwap(true, false) {
+			close(h.paused)
+			<-h.release
+		}
+
+		return false
+	})
+
+	return nil
+}
+
+func TestCachedUserRepository_ReadUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	newRepo := func(t *testing.T) (*rootpkg.CachedUserRepository, *countingDB) {
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		db := &countingDB{DB: postgres.DB()}
+		cache := rootpkg.NewLRUCache(100, time.Minute)
+
+		return rootpkg.NewCachedUserRepository(rootpkg.NewUserRepository(db), cache), db
+	}
+
+	t.Run("Second read issues no SQL", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo, db := newRepo(t)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		calls := db.calls.Load()
+
+		// Act
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+		require.Equal(t, calls, db.calls.Load())
+	})
+
+	t.Run("Concurrent reads issue a single query", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo, db := newRepo(t)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		calls := db.calls.Load()
+
+		// Act
+		wg := sync.WaitGroup{}
+		errs := make([]error, 10)
+
+		for i := range errs {
+			wg.Go(func() {
+				_, errs[i] = repo.ReadUser(tenantContext(), user.ID)
+			})
+		}
+
+		wg.Wait()
+
+		// Assert
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, calls+1, db.calls.Load(), "only one transaction must be started")
+	})
+
+	t.Run("Update invalidates the user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo, _ := newRepo(t)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		user.Username = "updated-gopher"
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), user, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "updated-gopher", gotUser.Username)
+		require.Equal(t, user.Version+1, gotUser.Version)
+	})
+
+	t.Run("Delete invalidates the user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo, _ := newRepo(t)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.DeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+
+	t.Run("Soft deletion invalidates the user", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo, _ := newRepo(t)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.SoftDeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+
+	t.Run("Read in flight during update is not cached", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		handler := newPausingHandler()
+		repo := rootpkg.NewCachedUserRepository(
+			rootpkg.NewUserRepository(rootpkg.NewLoggingDB(postgres.DB(), slog.New(handler))),
+			rootpkg.NewLRUCache(100, 0),
+		)
+
+		user := newFullyFiledUser()
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		handler.armed.Store(true)
+
+		read := make(chan error, 1)
+		go func() {
+			_, err := repo.ReadUser(tenantContext(), user.ID)
+			read <- err
+		}()
+
+		<-handler.paused
+
+		user.Username = "updated-gopher"
+
+		// Act
+		err := repo.UpdateUser(tenantContext(), user, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.NoError(t, err)
+
+		close(handler.release)
+		require.NoError(t, <-read)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "updated-gopher", gotUser.Username)
+	})
+
+	t.Run("Update in transaction invalidates the user after commit", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewCachedUserRepository(
+			rootpkg.NewUserRepository(postgres.DB()),
+			rootpkg.NewLRUCache(100, 0),
+		)
+		txManager := rootpkg.NewTxManager(postgres.DB())
+
+		user := newFullyFiledUser()
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		committedUsername := user.Username
+		user.Username = "updated-gopher"
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.UpdateUser(ctx, user, rootpkg.UserFieldUsername); err != nil {
+				return err
+			}
+
+			// The read outside the transaction caches the committed state.
+			gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+			if err != nil {
+				return err
+			}
+
+			require.Equal(t, committedUsername, gotUser.Username)
+
+			return nil
+		})
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+		require.Equal(t, "updated-gopher", gotUser.Username)
+	})
+
+	t.Run("User is cached per tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		repo, _ := newRepo(t)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		// Act
+		_, err = repo.ReadUser(rootpkg.WithTenant(context.Background(), uuid.New()), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SearchUsers returns at most limit active users whose username is similar
+// to the query or contains it, the comparison is case-insensitive. Users are
+// ranked by the trigram similarity of the username to the query, so the
+// exact match is the first. Objects of pg_trgm are qualified by the public
+// schema in which the extension is installed.
+func (r *UserRepository) SearchUsers(ctx context.Context, query string, limit int) ([]User, error) {
+	const sqlStr = `SELECT user_id, username, created_at, version, deleted_at FROM users
+WHERE deleted_at IS NULL
+  AND (lower(username) OPERATOR(public.%) lower($1) OR lower(username) LIKE '%' || lower($2) || '%')
+ORDER BY public.similarity(lower(username), lower($1)) DESC, lower(username), user_id
+LIMIT $3;`
+
+	const format = "failed search of Users in database: %w"
+
+	if limit <= 0 {
+		return nil, fmt.Errorf(format, fmt.Errorf("non-positive limit %d", limit))
+	}
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []User{}, nil
+	}
+
+	var users []User
+
+	err := r.scoped(ctx, "SearchUsers", func(ctx context.Context) error {
+		rows, err := r.conn(ctx).QueryContext(ctx, sqlStr, query, escapeLike(query), limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		users, err = scanUsers(rows, 0)
+		if err != nil {
+			return err
+		}
+
+		recordReturnedRows(ctx, len(users))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(format, err)
+	}
+
+	return users, nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+// countCachedStatements returns the number of statements of INSERT INTO users
+// prepared by pgx in the session of db, they are named stmtcache_<digest>.
+func countCachedStatements(t *testing.T, db rootpkg.DB) int {
+	t.Helper()
+
+	const query = `SELECT count(*) FROM pg_prepared_statements
+WHERE statement LIKE 'INSERT INTO users%' AND name LIKE 'stmtcache\_%';`
+
+	var count int
+	require.NoError(t, db.QueryRowContext(context.Background(), query).Scan(&count))
+
+	return count
+}
+
+func TestUserRepository_StatementCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	newUser := func() rootpkg.User {
+		return rootpkg.User{ID: uuid.New(), Username: uuid.NewString(), CreatedAt: time.Now()}
+	}
+
+	t.Run("Statement is prepared once per connection", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+
+		// A single connection is shared by the calls and the query of
+		// pg_prepared_statements, which are visible only in their session.
+		db := postgres.DB()
+		db.SetMaxOpenConns(1)
+
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		for range 3 {
+			user := newUser()
+
+			require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+			got, err := repo.ReadUser(tenantContext(), user.ID)
+			require.NoError(t, err)
+			require.Equal(t, user.Username, got.Username)
+		}
+
+		// Assert
+		require.Equal(t, 1, countCachedStatements(t, db))
+	})
+
+	t.Run("Statement is cached in transaction of decorated database", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+
+		db := postgres.DB()
+		db.SetMaxOpenConns(1)
+
+		decorated := rootpkg.NewLoggingDB(db, testingpg.NewLogger(t))
+		repo := rootpkg.NewUserRepository(decorated)
+		txManager := rootpkg.NewTxManager(decorated)
+
+		require.NoError(t, repo.CreateUser(tenantContext(), newUser()))
+
+		user := newUser()
+		errTest := errors.New("test error")
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.CreateUser(ctx, user); err != nil {
+				return err
+			}
+
+			return errTest
+		})
+
+		// Assert
+		require.ErrorIs(t, err, errTest)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+		require.Equal(t, 1, countCachedStatements(t, db))
+	})
+}
+
+// benchmarkRepositories runs fn with the repository on the database whose
+// queries are prepared and cached by pgx, which is the default mode of pgx,
+// and with the repository on the database which sends queries without
+// preparation, so the difference is the cost of parsing and planning of the
+// query by every call.
+func benchmarkRepositories(b *testing.B, fn func(b *testing.B, repo *rootpkg.UserRepository)) {
+	b.Helper()
+
+	if testing.Short() {
+		b.Skip("skipping benchmark in short mode")
+	}
+
+	b.Run("StatementCache", func(b *testing.B) {
+		postgres := testingpg.NewWithIsolatedDatabase(b, testingpg.WithDedicatedRole("app"))
+
+		fn(b, rootpkg.NewUserRepository(postgres.DB()))
+	})
+
+	b.Run("Exec", func(b *testing.B) {
+		postgres := testingpg.NewWithIsolatedDatabase(b, testingpg.WithDedicatedRole("app"))
+
+		config, err := pgx.ParseConfig(postgres.URL())
+		require.NoError(b, err)
+
+		config.DefaultQueryExecMode = pgx.QueryExecModeExec
+
+		db := stdlib.OpenDB(*config)
+		b.Cleanup(func() { require.NoError(b, db.Close()) })
+
+		fn(b, rootpkg.NewUserRepository(db))
+	})
+}
+
+func BenchmarkUserRepository_ReadUser(b *testing.B) {
+	benchmarkRepositories(b, func(b *testing.B, repo *rootpkg.UserRepository) {
+		user := rootpkg.User{ID: uuid.New(), Username: "gopher", CreatedAt: time.Now()}
+		require.NoError(b, repo.CreateUser(tenantContext(), user))
+
+		for b.Loop() {
+			_, err := repo.ReadUser(tenantContext(), user.ID)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkUserRepository_CreateUser(b *testing.B) {
+	benchmarkRepositories(b, func(b *testing.B, repo *rootpkg.UserRepository) {
+		for b.Loop() {
+			user := rootpkg.User{ID: uuid.New(), Username: uuid.NewString(), CreatedAt: time.Now()}
+			require.NoError(b, repo.CreateUser(tenantContext(), user))
+		}
+	})
+}
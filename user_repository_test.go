@@ -4,9 +4,44 @@
 
 package testing_go_code_with_postgres_test
 
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+)
+
 // Since the repository contains examples of different approaches to
-// database cleanup, this file is empty. The specific tests can be found
-// in the following files:
+// database cleanup, this file contains only helpers shared by them. The
+// specific tests can be found in the following files:
 // - user_repository_with_isolated_database_test.go
 // - user_repository_with_transactional_cleanup_test.go
 // - user_repository_with_isolated_schema_test.go
+//
+// Integration tests of the other types, like TxManager or UserWatcher, use
+// isolated databases and are in the test files of the types.
+
+// testTenantID is the tenant of tests which do not check the isolation of
+// tenants, so they behave as if there were a single tenant.
+var testTenantID = uuid.New()
+
+// tenantContext returns the context with testTenantID, UserRepository
+// requires the tenant in the context of every call.
+func tenantContext() context.Context {
+	return rootpkg.WithTenant(context.Background(), testTenantID)
+}
+
+// newFullyFiledUser returns the user with all fields filled. The username is
+// unique, so that users created by tests sharing the database do not
+// conflict.
+func newFullyFiledUser() rootpkg.User {
+	id := uuid.New()
+
+	return rootpkg.User{
+		ID:        id,
+		Username:  "gopher-" + id.String()[:8],
+		CreatedAt: time.Now().Truncate(time.Microsecond),
+	}
+}
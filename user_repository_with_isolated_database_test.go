@@ -5,7 +5,14 @@
 package testing_go_code_with_postgres_test
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,30 +30,22 @@ func TestUserRepository_CreateUser(t *testing.T) {
 
 	t.Parallel()
 
-	newFullyFiledUser := func() rootpkg.User {
-		return rootpkg.User{
-			ID:        uuid.New(),
-			Username:  "gopher",
-			CreatedAt: time.Now().Truncate(time.Microsecond),
-		}
-	}
-
 	t.Run("Successfully created a User", func(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		postgres := testingpg.NewWithIsolatedDatabase(t)
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
 		repo := rootpkg.NewUserRepository(postgres.DB())
 
 		user := newFullyFiledUser()
 
 		// Act
-		err := repo.CreateUser(context.Background(), user)
+		err := repo.CreateUser(tenantContext(), user)
 
 		// Assert
 		require.NoError(t, err)
 
-		gotUser, err := repo.ReadUser(context.Background(), user.ID)
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
 		require.NoError(t, err)
 
 		require.Equal(t, user, gotUser)
@@ -56,20 +55,110 @@ func TestUserRepository_CreateUser(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		postgres := testingpg.NewWithIsolatedDatabase(t)
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
 		repo := rootpkg.NewUserRepository(postgres.DB())
 
 		user := newFullyFiledUser()
 
-		err := repo.CreateUser(context.Background(), user)
+		err := repo.CreateUser(tenantContext(), user)
 		require.NoError(t, err)
 
+		// The username is changed, so that only the ID is duplicated.
+		user.Username = "another-gopher"
+
 		// Act
-		err = repo.CreateUser(context.Background(), user)
+		err = repo.CreateUser(tenantContext(), user)
 
 		// Assert
-		require.Error(t, err)
-		require.Contains(t, err.Error(), "duplicate key value violates unique constraint")
+		require.ErrorIs(t, err, rootpkg.ErrUserIDConflict)
+	})
+
+	t.Run("Cannot create a user with the same username", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		user.ID = uuid.New()
+
+		// Act
+		err = repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Cannot create a user with the same username in different case", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		user.ID = uuid.New()
+		user.Username = strings.ToUpper(user.Username)
+
+		// Act
+		err = repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Cannot create a user with invalid username", func(t *testing.T) {
+		t.Parallel()
+
+		for _, username := range []string{"go", "go pher", "gopher!", strings.Repeat("g", 51)} {
+			t.Run(username, func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+				repo := rootpkg.NewUserRepository(postgres.DB())
+
+				user := newFullyFiledUser()
+				user.Username = username
+
+				// Act
+				err := repo.CreateUser(tenantContext(), user)
+
+				// Assert
+				require.ErrorIs(t, err, rootpkg.ErrInvalidUsername)
+			})
+		}
+	})
+}
+
+func TestUser_SchemaDrift(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("User matches the users table of the migrations", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t)
+
+		// The tenant is not a field of User, it is set by the database from
+		// the tenant of the context.
+		ignored := []string{"tenant_id"}
+
+		// Assert
+		testingpg.RequireNoSchemaDrift(t, postgres.DB(), "users", rootpkg.User{}, ignored...)
 	})
 }
 
@@ -84,13 +173,1272 @@ func TestUserRepository_ReadUser(t *testing.T) {
 		t.Parallel()
 
 		// Arrange
-		postgres := testingpg.NewWithIsolatedDatabase(t)
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		// Act
+		_, err := repo.ReadUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_ReadUserByUsername(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully read a User by username in any case", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		username := strings.ToUpper(user.Username)
+
+		// Act
+		gotUser, err := repo.ReadUserByUsername(tenantContext(), username)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Get an error if the user does not exist", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		// Act
+		_, err := repo.ReadUserByUsername(tenantContext(), newFullyFiledUser().Username)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_UpdateUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully updated only fields in the mask", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+		changed.CreatedAt = user.CreatedAt.Add(-time.Hour)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), changed, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		require.Equal(t, changed.Username, gotUser.Username)
+		require.Equal(t, user.CreatedAt, gotUser.CreatedAt)
+	})
+
+	t.Run("Successfully updated all fields with empty mask", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+		changed.CreatedAt = user.CreatedAt.Add(-time.Hour)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), changed)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		changed.Version++
+
+		require.Equal(t, changed, gotUser)
+	})
+
+	t.Run("Cannot update a user with a stale version", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+
+		require.NoError(t, repo.UpdateUser(tenantContext(), changed))
+
+		stale := user
+		stale.CreatedAt = user.CreatedAt.Add(-time.Hour)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), stale)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrConcurrentModification)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		require.Equal(t, changed.Username, gotUser.Username)
+		require.Equal(t, user.CreatedAt, gotUser.CreatedAt)
+		require.Equal(t, user.Version+1, gotUser.Version)
+	})
+
+	t.Run("Get an error if the user does not exist", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		// Act
+		err := repo.UpdateUser(tenantContext(), newFullyFiledUser())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+
+	t.Run("Cannot update username to the taken one", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user1))
+		require.NoError(t, repo.CreateUser(tenantContext(), user2))
+
+		user2.Username = user1.Username
+
+		// Act
+		err := repo.UpdateUser(tenantContext(), user2, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Cannot update unknown field", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
 		repo := rootpkg.NewUserRepository(postgres.DB())
 
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
 		// Act
-		_, err := repo.ReadUser(context.Background(), uuid.New())
+		err = repo.UpdateUser(tenantContext(), user, "user_id")
 
 		// Assert
 		require.Error(t, err)
+		require.NotErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_UpdateUser_Concurrently(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	// Arrange
+	postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+	repo := rootpkg.NewUserRepository(postgres.DB())
+
+	id := uuid.New()
+	user := rootpkg.User{
+		ID:        id,
+		Username:  "gopher-" + id.String()[:8],
+		CreatedAt: time.Now().Truncate(time.Microsecond),
+	}
+
+	require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+	// Both writers have read the same version of the user, so without the
+	// version check the second update would silently overwrite the first one.
+	changes := []rootpkg.User{user, user}
+	changes[0].Username = user.Username + "-a"
+	changes[1].Username = user.Username + "-b"
+
+	start := make(chan struct{})
+	errs := make([]error, len(changes))
+	wg := sync.WaitGroup{}
+
+	// Act
+	for i, changed := range changes {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			<-start
+
+			errs[i] = repo.UpdateUser(tenantContext(), changed, rootpkg.UserFieldUsername)
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	// Assert
+	winner := slices.IndexFunc(errs, func(err error) bool { return err == nil })
+	require.NotEqual(t, -1, winner, "one of the updates must succeed: %v", errs)
+	require.ErrorIs(t, errs[1-winner], rootpkg.ErrConcurrentModification)
+
+	gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, changes[winner].Username, gotUser.Username)
+	require.Equal(t, user.Version+1, gotUser.Version)
+}
+
+func TestUserRepository_DeleteUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully deleted a User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.DeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+
+	t.Run("Get an error if the user does not exist", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		// Act
+		err := repo.DeleteUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_SoftDeleteUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Soft-deleted user is hidden by default", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.SoftDeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		_, err = repo.ReadUserByUsername(tenantContext(), user.Username)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: user.Username}
+
+		users, _, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+		require.NoError(t, err)
+		require.Empty(t, users)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID, rootpkg.WithDeleted())
+		require.NoError(t, err)
+		require.NotNil(t, gotUser.DeletedAt)
+		require.Equal(t, user.Version+1, gotUser.Version)
+
+		filter.IncludeDeleted = true
+
+		users, _, err = repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+		require.NoError(t, err)
+		require.Equal(t, []rootpkg.User{gotUser}, users)
+	})
+
+	t.Run("Username of soft-deleted user can be reclaimed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		deleted := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), deleted))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), deleted.ID))
+
+		user := newFullyFiledUser()
+		user.Username = strings.ToUpper(deleted.Username)
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUserByUsername(
+			tenantContext(),
+			deleted.Username,
+			rootpkg.WithDeleted(),
+		)
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Get an error if the user is already deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		err := repo.SoftDeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_RestoreUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully restored a User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		err := repo.RestoreUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		user.Version += 2
+
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Cannot restore a user whose username is taken", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		other := newFullyFiledUser()
+		other.Username = user.Username
+
+		require.NoError(t, repo.CreateUser(tenantContext(), other))
+
+		// Act
+		err := repo.RestoreUser(tenantContext(), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Get an error if the user is not deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		// Act
+		err := repo.RestoreUser(tenantContext(), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func TestUserRepository_PurgeDeletedUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Purged only users deleted before the retention window", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		active := newFullyFiledUser()
+		deleted := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), active))
+		require.NoError(t, repo.CreateUser(tenantContext(), deleted))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), deleted.ID))
+
+		// Act
+		retained, err := repo.PurgeDeletedUsers(tenantContext(), time.Hour)
+		require.NoError(t, err)
+
+		purged, err := repo.PurgeDeletedUsers(tenantContext(), 0)
+		require.NoError(t, err)
+
+		// Assert
+		require.Zero(t, retained)
+		require.Equal(t, int64(1), purged)
+
+		_, err = repo.ReadUser(tenantContext(), deleted.ID, rootpkg.WithDeleted())
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		gotUser, err := repo.ReadUser(tenantContext(), active.ID)
+		require.NoError(t, err)
+		require.Equal(t, active, gotUser)
+	})
+}
+
+func TestUserRepository_ReadUserHistory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Changes are recorded with the actor of the context", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		ctx := rootpkg.WithActor(tenantContext(), "admin")
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(ctx, user))
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+
+		require.NoError(t, repo.UpdateUser(ctx, changed))
+		require.NoError(t, repo.DeleteUser(ctx, user.ID))
+
+		// Act
+		history, err := repo.ReadUserHistory(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+
+		wantOperations := []rootpkg.ChangeOp{
+			rootpkg.ChangeInsert,
+			rootpkg.ChangeUpdate,
+			rootpkg.ChangeDelete,
+		}
+
+		for i, entry := range history {
+			require.Equal(t, user.ID, entry.UserID)
+			require.Equal(t, wantOperations[i], entry.Operation)
+			require.Equal(t, "admin", entry.Actor)
+			require.False(t, entry.ChangedAt.IsZero())
+		}
+
+		require.Nil(t, history[0].OldRow)
+		require.Nil(t, history[2].NewRow)
+
+		oldRow := map[string]any{}
+		require.NoError(t, json.Unmarshal(history[1].OldRow, &oldRow))
+		require.Equal(t, user.Username, oldRow["username"])
+
+		newRow := map[string]any{}
+		require.NoError(t, json.Unmarshal(history[1].NewRow, &newRow))
+		require.Equal(t, changed.Username, newRow["username"])
+	})
+
+	t.Run("Actor is not recorded for changes without it", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(rootpkg.WithActor(tenantContext(), "admin"), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		history, err := repo.ReadUserHistory(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, "admin", history[0].Actor)
+		require.Empty(t, history[1].Actor)
+	})
+
+	t.Run("History of unknown user is empty", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		// Act
+		history, err := repo.ReadUserHistory(tenantContext(), uuid.New())
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, history)
+	})
+}
+
+func TestUserRepository_UpsertUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully created a User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		// Act
+		err := repo.UpsertUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Successfully replaced an existing User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		user.Username = newFullyFiledUser().Username
+
+		// Act
+		err = repo.UpsertUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		user.Version++
+
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Cannot upsert a user with the taken username", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user1))
+
+		user2.Username = user1.Username
+
+		// Act
+		err := repo.UpsertUser(tenantContext(), user2)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+}
+
+func TestUserRepository_ListUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	// createUsers creates users with a unique username prefix, pairs of users
+	// have the same creation time. It returns the prefix and the users in the
+	// order of listing.
+	createUsers := func(t *testing.T, repo *rootpkg.UserRepository) (string, []rootpkg.User) {
+		prefix := "list-" + uuid.NewString()[:8] + "-"
+		createdAt := time.Now().Truncate(time.Microsecond)
+
+		users := make([]rootpkg.User, 5)
+		for i := range users {
+			users[i] = rootpkg.User{
+				ID:        uuid.New(),
+				Username:  fmt.Sprintf("%s%d", prefix, i),
+				CreatedAt: createdAt.Add(time.Duration(i/2) * time.Second),
+			}
+
+			require.NoError(t, repo.CreateUser(tenantContext(), users[i]))
+		}
+
+		slices.SortFunc(users, func(a, b rootpkg.User) int {
+			return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), bytes.Compare(a.ID[:], b.ID[:]))
+		})
+
+		return prefix, users
+	}
+
+	t.Run("Successfully listed users page by page", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		prefix, users := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: prefix}
+		page := rootpkg.Page{Size: 2}
+
+		var gotUsers []rootpkg.User
+
+		pages := 0
+
+		// Act
+		for {
+			pageUsers, next, err := repo.ListUsers(tenantContext(), filter, page)
+			require.NoError(t, err)
+
+			gotUsers = append(gotUsers, pageUsers...)
+			pages++
+
+			if next == "" {
+				break
+			}
+
+			page.Cursor = next
+		}
+
+		// Assert
+		require.Equal(t, 3, pages)
+		require.Equal(t, users, gotUsers)
+	})
+
+	t.Run("Successfully filtered by username prefix in any case", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		_, users := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: strings.ToUpper(users[2].Username)}
+
+		// Act
+		gotUsers, next, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, next)
+		require.Equal(t, users[2:3], gotUsers)
+	})
+
+	t.Run("Wildcards in username prefix are matched literally", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		prefix, _ := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: strings.ReplaceAll(prefix, "-", "_")}
+
+		// Act
+		gotUsers, _, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, gotUsers)
+	})
+
+	t.Run("Successfully filtered by creation time range", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		prefix, users := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{
+			UsernamePrefix: prefix,
+			CreatedFrom:    users[2].CreatedAt,
+			CreatedTo:      users[4].CreatedAt,
+		}
+
+		// Act
+		gotUsers, _, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, users[2:4], gotUsers)
+	})
+
+	t.Run("Get an error if the cursor is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		page := rootpkg.Page{Cursor: "not a cursor"}
+
+		// Act
+		_, _, err := repo.ListUsers(tenantContext(), rootpkg.UserFilter{}, page)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrInvalidCursor)
+	})
+}
+
+func TestUserRepository_SearchUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	newUser := func(username string) rootpkg.User {
+		return rootpkg.User{
+			ID:        uuid.New(),
+			Username:  username,
+			CreatedAt: time.Now().Truncate(time.Microsecond),
+		}
+	}
+
+	usernames := func(users []rootpkg.User) []string {
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Username)
+		}
+
+		return names
+	}
+
+	// Usernames are unique for every test, because users of concurrent
+	// transactions with the same username would block each other. The suffix
+	// is separated by a hyphen, so pg_trgm treats it as a separate word.
+	newSuffix := func() string {
+		return "-" + uuid.New().String()[:8]
+	}
+
+	createUsers := func(t *testing.T, repo *rootpkg.UserRepository, names ...string) {
+		t.Helper()
+
+		for _, name := range names {
+			require.NoError(t, repo.CreateUser(tenantContext(), newUser(name)))
+		}
+	}
+
+	t.Run("Users are ranked by similarity", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(
+			t,
+			testingpg.WithDedicatedRole("app"),
+			testingpg.WithExtensions("pg_trgm"),
+		)
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		sfx := newSuffix()
+		createUsers(t, repo, "marmoset"+sfx, "marmots"+sfx, "Marmot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "marmot"+sfx, 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"Marmot" + sfx, "marmots" + sfx, "marmoset" + sfx}, usernames(users))
+	})
+
+	t.Run("Users containing the query are found", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(
+			t,
+			testingpg.WithDedicatedRole("app"),
+			testingpg.WithExtensions("pg_trgm"),
+		)
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		sfx := newSuffix()
+		createUsers(t, repo, "marmoset"+sfx, "gopher"+sfx, "Marmot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "ARMO", 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"Marmot" + sfx, "marmoset" + sfx}, usernames(users))
+	})
+
+	t.Run("Number of users is limited", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(
+			t,
+			testingpg.WithDedicatedRole("app"),
+			testingpg.WithExtensions("pg_trgm"),
+		)
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		sfx := newSuffix()
+		createUsers(t, repo, "marmoset"+sfx, "marmots"+sfx, "Marmot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "marmot"+sfx, 1)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"Marmot" + sfx}, usernames(users))
+	})
+
+	t.Run("Soft-deleted users are not found", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(
+			t,
+			testingpg.WithDedicatedRole("app"),
+			testingpg.WithExtensions("pg_trgm"),
+		)
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		sfx := newSuffix()
+		user := newUser("marmot" + sfx)
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "marmot"+sfx, 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+
+	t.Run("Wildcards of the query are matched literally", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(
+			t,
+			testingpg.WithDedicatedRole("app"),
+			testingpg.WithExtensions("pg_trgm"),
+		)
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		sfx := newSuffix()
+		createUsers(t, repo, "marmot"+sfx, "mar_mot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "r_m", 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"mar_mot" + sfx}, usernames(users))
+	})
+}
+
+func TestUserRepository_CreateUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	// newUsers returns users with a unique username prefix in the order of
+	// listing.
+	newUsers := func(count int) (string, []rootpkg.User) {
+		prefix := "batch-" + uuid.NewString()[:8] + "-"
+		createdAt := time.Now().Truncate(time.Microsecond)
+
+		users := make([]rootpkg.User, count)
+		for i := range users {
+			users[i] = rootpkg.User{
+				ID:        uuid.New(),
+				Username:  fmt.Sprintf("%s%d", prefix, i),
+				CreatedAt: createdAt.Add(time.Duration(i) * time.Microsecond),
+			}
+		}
+
+		return prefix, users
+	}
+
+	t.Run("Successfully created many users", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		prefix, users := newUsers(1000)
+
+		// Act
+		err := repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		require.NoError(t, err)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: prefix}
+		page := rootpkg.Page{Size: len(users)}
+
+		gotUsers, _, err := repo.ListUsers(tenantContext(), filter, page)
+		require.NoError(t, err)
+
+		require.Equal(t, users, gotUsers)
+	})
+
+	t.Run("Users are created in the tenant of the context", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		ctx := rootpkg.WithTenant(context.Background(), uuid.New())
+		prefix, users := newUsers(3)
+
+		// Act
+		err := repo.CreateUsers(ctx, users)
+
+		// Assert
+		require.NoError(t, err)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: prefix}
+		page := rootpkg.Page{Size: len(users)}
+
+		gotUsers, _, err := repo.ListUsers(ctx, filter, page)
+		require.NoError(t, err)
+		require.Equal(t, users, gotUsers)
+
+		otherUsers, _, err := repo.ListUsers(tenantContext(), filter, page)
+		require.NoError(t, err)
+		require.Empty(t, otherUsers)
+	})
+
+	t.Run("Conflict with an existing user is reported with index", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		_, users := newUsers(3)
+
+		err := repo.CreateUser(tenantContext(), users[1])
+		require.NoError(t, err)
+
+		users[1].ID = uuid.New()
+
+		// Act
+		err = repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		batchErr := &rootpkg.BatchError{}
+		require.ErrorAs(t, err, &batchErr)
+		require.Equal(t, 1, batchErr.Index)
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+		_, err = repo.ReadUser(tenantContext(), users[0].ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound, "batch must be atomic")
+	})
+
+	t.Run("Duplicated ID in the batch is reported with index", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		_, users := newUsers(3)
+		users[2].ID = users[0].ID
+
+		// Act
+		err := repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		batchErr := &rootpkg.BatchError{}
+		require.ErrorAs(t, err, &batchErr)
+		require.Equal(t, 2, batchErr.Index)
+		require.ErrorIs(t, err, rootpkg.ErrUserIDConflict)
+	})
+}
+
+func TestUserRepository_Tenants(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	newUser := func() rootpkg.User {
+		return rootpkg.User{
+			ID:        uuid.New(),
+			Username:  "gopher",
+			CreatedAt: time.Now().Truncate(time.Microsecond),
+		}
+	}
+
+	newTenantContext := func() context.Context {
+		return rootpkg.WithTenant(context.Background(), uuid.New())
+	}
+
+	t.Run("User of another tenant is not found", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		ctx, anotherCtx := newTenantContext(), newTenantContext()
+
+		user := newUser()
+
+		err := repo.CreateUser(ctx, user)
+		require.NoError(t, err)
+
+		// Act
+		_, readErr := repo.ReadUser(anotherCtx, user.ID)
+		_, readByUsernameErr := repo.ReadUserByUsername(anotherCtx, user.Username)
+		users, _, listErr := repo.ListUsers(anotherCtx, rootpkg.UserFilter{}, rootpkg.Page{})
+		found, searchErr := repo.SearchUsers(anotherCtx, user.Username, 10)
+		history, historyErr := repo.ReadUserHistory(anotherCtx, user.ID)
+
+		// Assert
+		require.ErrorIs(t, readErr, rootpkg.ErrUserNotFound)
+		require.ErrorIs(t, readByUsernameErr, rootpkg.ErrUserNotFound)
+		require.NoError(t, listErr)
+		require.Empty(t, users)
+		require.NoError(t, searchErr)
+		require.Empty(t, found)
+		require.NoError(t, historyErr)
+		require.Empty(t, history)
+	})
+
+	t.Run("User of another tenant cannot be changed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		ctx, anotherCtx := newTenantContext(), newTenantContext()
+
+		user := newUser()
+
+		err := repo.CreateUser(ctx, user)
+		require.NoError(t, err)
+
+		// Act
+		updateErr := repo.UpdateUser(anotherCtx, user)
+		softDeleteErr := repo.SoftDeleteUser(anotherCtx, user.ID)
+		deleteErr := repo.DeleteUser(anotherCtx, user.ID)
+
+		// Assert
+		require.ErrorIs(t, updateErr, rootpkg.ErrUserNotFound)
+		require.ErrorIs(t, softDeleteErr, rootpkg.ErrUserNotFound)
+		require.ErrorIs(t, deleteErr, rootpkg.ErrUserNotFound)
+
+		gotUser, err := repo.ReadUser(ctx, user.ID)
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Username can be taken in another tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		ctx, anotherCtx := newTenantContext(), newTenantContext()
+
+		user := newUser()
+
+		err := repo.CreateUser(ctx, user)
+		require.NoError(t, err)
+
+		anotherUser := newUser()
+
+		// Act
+		err = repo.CreateUser(anotherCtx, anotherUser)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUserByUsername(anotherCtx, user.Username)
+		require.NoError(t, err)
+		require.Equal(t, anotherUser, gotUser)
+	})
+
+	t.Run("Handle of the tenant sees only users of the tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		tenantID, anotherTenantID := uuid.New(), uuid.New()
+
+		err := repo.CreateUser(rootpkg.WithTenant(context.Background(), tenantID), newUser())
+		require.NoError(t, err)
+
+		countUsers := func(t *testing.T, postgres *testingpg.Postgres) int {
+			var count int
+
+			err := postgres.DB().QueryRowContext(
+				context.Background(),
+				"SELECT count(*) FROM users;",
+			).Scan(&count)
+			require.NoError(t, err)
+
+			return count
+		}
+
+		// Act
+		tenantCount := countUsers(t, postgres.Tenant(tenantID.String()))
+		anotherTenantCount := countUsers(t, postgres.Tenant(anotherTenantID.String()))
+		withoutTenantCount := countUsers(t, postgres)
+
+		// Assert
+		require.Equal(t, 1, tenantCount)
+		require.Zero(t, anotherTenantCount)
+		require.Zero(t, withoutTenantCount)
+	})
+
+	t.Run("User cannot be created without the tenant", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+
+		// Act
+		err := repo.CreateUser(context.Background(), newUser())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrTenantRequired)
 	})
 }
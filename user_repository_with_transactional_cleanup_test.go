@@ -5,11 +5,20 @@
 package testing_go_code_with_postgres_test
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/require"
 
 	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
@@ -23,14 +32,6 @@ func Test_Transactional_UserRepository_CreateUser(t *testing.T) {
 
 	t.Parallel()
 
-	newFullyFiledUser := func() rootpkg.User {
-		return rootpkg.User{
-			ID:        uuid.New(),
-			Username:  "gopher",
-			CreatedAt: time.Now().Truncate(time.Microsecond),
-		}
-	}
-
 	t.Run("Successfully created a User", func(t *testing.T) {
 		t.Parallel()
 
@@ -41,12 +42,12 @@ func Test_Transactional_UserRepository_CreateUser(t *testing.T) {
 		user := newFullyFiledUser()
 
 		// Act
-		err := repo.CreateUser(context.Background(), user)
+		err := repo.CreateUser(tenantContext(), user)
 
 		// Assert
 		require.NoError(t, err)
 
-		gotUser, err := repo.ReadUser(context.Background(), user.ID)
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
 		require.NoError(t, err)
 
 		require.Equal(t, user, gotUser)
@@ -61,15 +62,83 @@ func Test_Transactional_UserRepository_CreateUser(t *testing.T) {
 
 		user := newFullyFiledUser()
 
-		err := repo.CreateUser(context.Background(), user)
+		err := repo.CreateUser(tenantContext(), user)
 		require.NoError(t, err)
 
+		// The username is changed, so that only the ID is duplicated.
+		user.Username = "another-gopher"
+
 		// Act
-		err = repo.CreateUser(context.Background(), user)
+		err = repo.CreateUser(tenantContext(), user)
 
 		// Assert
-		require.Error(t, err)
-		require.Contains(t, err.Error(), "duplicate key value violates unique constraint")
+		require.ErrorIs(t, err, rootpkg.ErrUserIDConflict)
+	})
+
+	t.Run("Cannot create a user with the same username", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		user.ID = uuid.New()
+
+		// Act
+		err = repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Cannot create a user with the same username in different case", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		user.ID = uuid.New()
+		user.Username = strings.ToUpper(user.Username)
+
+		// Act
+		err = repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Cannot create a user with invalid username", func(t *testing.T) {
+		t.Parallel()
+
+		for _, username := range []string{"go", "go pher", "gopher!", strings.Repeat("g", 51)} {
+			t.Run(username, func(t *testing.T) {
+				t.Parallel()
+
+				// Arrange
+				db := testingpg.NewWithTransactionalCleanup(t)
+				repo := rootpkg.NewUserRepository(db)
+
+				user := newFullyFiledUser()
+				user.Username = username
+
+				// Act
+				err := repo.CreateUser(tenantContext(), user)
+
+				// Assert
+				require.ErrorIs(t, err, rootpkg.ErrInvalidUsername)
+			})
+		}
 	})
 }
 
@@ -88,9 +157,1218 @@ func Test_Transactional_UserRepository_ReadUser(t *testing.T) {
 		repo := rootpkg.NewUserRepository(db)
 
 		// Act
-		_, err := repo.ReadUser(context.Background(), uuid.New())
+		_, err := repo.ReadUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func Test_Transactional_UserRepository_ReadUserByUsername(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully read a User by username in any case", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		username := strings.ToUpper(user.Username)
+
+		// Act
+		gotUser, err := repo.ReadUserByUsername(tenantContext(), username)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Get an error if the user does not exist", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		_, err := repo.ReadUserByUsername(tenantContext(), newFullyFiledUser().Username)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func Test_Transactional_UserRepository_UpdateUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully updated only fields in the mask", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+		changed.CreatedAt = user.CreatedAt.Add(-time.Hour)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), changed, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		require.Equal(t, changed.Username, gotUser.Username)
+		require.Equal(t, user.CreatedAt, gotUser.CreatedAt)
+	})
+
+	t.Run("Successfully updated all fields with empty mask", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+		changed.CreatedAt = user.CreatedAt.Add(-time.Hour)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), changed)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		changed.Version++
+
+		require.Equal(t, changed, gotUser)
+	})
+
+	t.Run("Cannot update a user with a stale version", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+
+		require.NoError(t, repo.UpdateUser(tenantContext(), changed))
+
+		stale := user
+		stale.CreatedAt = user.CreatedAt.Add(-time.Hour)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), stale)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrConcurrentModification)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		require.Equal(t, changed.Username, gotUser.Username)
+		require.Equal(t, user.CreatedAt, gotUser.CreatedAt)
+		require.Equal(t, user.Version+1, gotUser.Version)
+	})
+
+	t.Run("Get an error if the user does not exist", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		err := repo.UpdateUser(tenantContext(), newFullyFiledUser())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+
+	t.Run("Cannot update username to the taken one", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user1))
+		require.NoError(t, repo.CreateUser(tenantContext(), user2))
+
+		user2.Username = user1.Username
+
+		// Act
+		err := repo.UpdateUser(tenantContext(), user2, rootpkg.UserFieldUsername)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Cannot update unknown field", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.UpdateUser(tenantContext(), user, "user_id")
 
 		// Assert
 		require.Error(t, err)
+		require.NotErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func Test_Transactional_UserRepository_DeleteUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully deleted a User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.DeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+
+	t.Run("Get an error if the user does not exist", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		err := repo.DeleteUser(tenantContext(), uuid.New())
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func Test_Transactional_UserRepository_SoftDeleteUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Soft-deleted user is hidden by default", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		// Act
+		err = repo.SoftDeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		_, err = repo.ReadUserByUsername(tenantContext(), user.Username)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: user.Username}
+
+		users, _, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+		require.NoError(t, err)
+		require.Empty(t, users)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID, rootpkg.WithDeleted())
+		require.NoError(t, err)
+		require.NotNil(t, gotUser.DeletedAt)
+		require.Equal(t, user.Version+1, gotUser.Version)
+
+		filter.IncludeDeleted = true
+
+		users, _, err = repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+		require.NoError(t, err)
+		require.Equal(t, []rootpkg.User{gotUser}, users)
+	})
+
+	t.Run("Username of soft-deleted user can be reclaimed", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		deleted := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), deleted))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), deleted.ID))
+
+		user := newFullyFiledUser()
+		user.Username = strings.ToUpper(deleted.Username)
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUserByUsername(
+			tenantContext(),
+			deleted.Username,
+			rootpkg.WithDeleted(),
+		)
+		require.NoError(t, err)
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Get an error if the user is already deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		err := repo.SoftDeleteUser(tenantContext(), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func Test_Transactional_UserRepository_RestoreUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully restored a User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		err := repo.RestoreUser(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		user.Version += 2
+
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Cannot restore a user whose username is taken", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		other := newFullyFiledUser()
+		other.Username = user.Username
+
+		require.NoError(t, repo.CreateUser(tenantContext(), other))
+
+		// Act
+		err := repo.RestoreUser(tenantContext(), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Get an error if the user is not deleted", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		// Act
+		err := repo.RestoreUser(tenantContext(), user.ID)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+	})
+}
+
+func Test_Transactional_UserRepository_PurgeDeletedUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Purged only users deleted before the retention window", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		active := newFullyFiledUser()
+		deleted := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), active))
+		require.NoError(t, repo.CreateUser(tenantContext(), deleted))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), deleted.ID))
+
+		// Act
+		retained, err := repo.PurgeDeletedUsers(tenantContext(), time.Hour)
+		require.NoError(t, err)
+
+		purged, err := repo.PurgeDeletedUsers(tenantContext(), 0)
+		require.NoError(t, err)
+
+		// Assert
+		require.Zero(t, retained)
+		require.Equal(t, int64(1), purged)
+
+		_, err = repo.ReadUser(tenantContext(), deleted.ID, rootpkg.WithDeleted())
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		gotUser, err := repo.ReadUser(tenantContext(), active.ID)
+		require.NoError(t, err)
+		require.Equal(t, active, gotUser)
+	})
+}
+
+func Test_Transactional_UserRepository_ReadUserHistory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Changes are recorded with the actor of the context", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		ctx := rootpkg.WithActor(tenantContext(), "admin")
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(ctx, user))
+
+		changed := user
+		changed.Username = newFullyFiledUser().Username
+
+		require.NoError(t, repo.UpdateUser(ctx, changed))
+		require.NoError(t, repo.DeleteUser(ctx, user.ID))
+
+		// Act
+		history, err := repo.ReadUserHistory(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+
+		wantOperations := []rootpkg.ChangeOp{
+			rootpkg.ChangeInsert,
+			rootpkg.ChangeUpdate,
+			rootpkg.ChangeDelete,
+		}
+
+		for i, entry := range history {
+			require.Equal(t, user.ID, entry.UserID)
+			require.Equal(t, wantOperations[i], entry.Operation)
+			require.Equal(t, "admin", entry.Actor)
+			require.False(t, entry.ChangedAt.IsZero())
+		}
+
+		require.Nil(t, history[0].OldRow)
+		require.Nil(t, history[2].NewRow)
+
+		oldRow := map[string]any{}
+		require.NoError(t, json.Unmarshal(history[1].OldRow, &oldRow))
+		require.Equal(t, user.Username, oldRow["username"])
+
+		newRow := map[string]any{}
+		require.NoError(t, json.Unmarshal(history[1].NewRow, &newRow))
+		require.Equal(t, changed.Username, newRow["username"])
+	})
+
+	t.Run("Actor is not recorded for changes without it", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(rootpkg.WithActor(tenantContext(), "admin"), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		history, err := repo.ReadUserHistory(tenantContext(), user.ID)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, "admin", history[0].Actor)
+		require.Empty(t, history[1].Actor)
+	})
+
+	t.Run("History of unknown user is empty", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		history, err := repo.ReadUserHistory(tenantContext(), uuid.New())
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, history)
+	})
+}
+
+func Test_Transactional_UserRepository_UpsertUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Successfully created a User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		// Act
+		err := repo.UpsertUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Successfully replaced an existing User", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := newFullyFiledUser()
+
+		err := repo.CreateUser(tenantContext(), user)
+		require.NoError(t, err)
+
+		user.Username = newFullyFiledUser().Username
+
+		// Act
+		err = repo.UpsertUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+		require.NoError(t, err)
+
+		user.Version++
+
+		require.Equal(t, user, gotUser)
+	})
+
+	t.Run("Cannot upsert a user with the taken username", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user1))
+
+		user2.Username = user1.Username
+
+		// Act
+		err := repo.UpsertUser(tenantContext(), user2)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+}
+
+func Test_Transactional_UserRepository_ListUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	// createUsers creates users with a unique username prefix, pairs of users
+	// have the same creation time. It returns the prefix and the users in the
+	// order of listing.
+	createUsers := func(t *testing.T, repo *rootpkg.UserRepository) (string, []rootpkg.User) {
+		prefix := "list-" + uuid.NewString()[:8] + "-"
+		createdAt := time.Now().Truncate(time.Microsecond)
+
+		users := make([]rootpkg.User, 5)
+		for i := range users {
+			users[i] = rootpkg.User{
+				ID:        uuid.New(),
+				Username:  fmt.Sprintf("%s%d", prefix, i),
+				CreatedAt: createdAt.Add(time.Duration(i/2) * time.Second),
+			}
+
+			require.NoError(t, repo.CreateUser(tenantContext(), users[i]))
+		}
+
+		slices.SortFunc(users, func(a, b rootpkg.User) int {
+			return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), bytes.Compare(a.ID[:], b.ID[:]))
+		})
+
+		return prefix, users
+	}
+
+	t.Run("Successfully listed users page by page", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		prefix, users := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: prefix}
+		page := rootpkg.Page{Size: 2}
+
+		var gotUsers []rootpkg.User
+
+		pages := 0
+
+		// Act
+		for {
+			pageUsers, next, err := repo.ListUsers(tenantContext(), filter, page)
+			require.NoError(t, err)
+
+			gotUsers = append(gotUsers, pageUsers...)
+			pages++
+
+			if next == "" {
+				break
+			}
+
+			page.Cursor = next
+		}
+
+		// Assert
+		require.Equal(t, 3, pages)
+		require.Equal(t, users, gotUsers)
+	})
+
+	t.Run("Successfully filtered by username prefix in any case", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		_, users := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: strings.ToUpper(users[2].Username)}
+
+		// Act
+		gotUsers, next, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, next)
+		require.Equal(t, users[2:3], gotUsers)
+	})
+
+	t.Run("Wildcards in username prefix are matched literally", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		prefix, _ := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: strings.ReplaceAll(prefix, "-", "_")}
+
+		// Act
+		gotUsers, _, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, gotUsers)
+	})
+
+	t.Run("Successfully filtered by creation time range", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		prefix, users := createUsers(t, repo)
+
+		filter := rootpkg.UserFilter{
+			UsernamePrefix: prefix,
+			CreatedFrom:    users[2].CreatedAt,
+			CreatedTo:      users[4].CreatedAt,
+		}
+
+		// Act
+		gotUsers, _, err := repo.ListUsers(tenantContext(), filter, rootpkg.Page{})
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, users[2:4], gotUsers)
+	})
+
+	t.Run("Get an error if the cursor is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		page := rootpkg.Page{Cursor: "not a cursor"}
+
+		// Act
+		_, _, err := repo.ListUsers(tenantContext(), rootpkg.UserFilter{}, page)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrInvalidCursor)
+	})
+}
+
+func Test_Transactional_UserRepository_SearchUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	newUser := func(username string) rootpkg.User {
+		return rootpkg.User{
+			ID:        uuid.New(),
+			Username:  username,
+			CreatedAt: time.Now().Truncate(time.Microsecond),
+		}
+	}
+
+	usernames := func(users []rootpkg.User) []string {
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Username)
+		}
+
+		return names
+	}
+
+	// Usernames are unique for every test, because users of concurrent
+	// transactions with the same username would block each other. The suffix
+	// is separated by a hyphen, so pg_trgm treats it as a separate word.
+	newSuffix := func() string {
+		return "-" + uuid.New().String()[:8]
+	}
+
+	createUsers := func(t *testing.T, repo *rootpkg.UserRepository, names ...string) {
+		t.Helper()
+
+		for _, name := range names {
+			require.NoError(t, repo.CreateUser(tenantContext(), newUser(name)))
+		}
+	}
+
+	t.Run("Users are ranked by similarity", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t, testingpg.WithExtensions("pg_trgm"))
+		repo := rootpkg.NewUserRepository(db)
+		sfx := newSuffix()
+		createUsers(t, repo, "marmoset"+sfx, "marmots"+sfx, "Marmot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "marmot"+sfx, 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"Marmot" + sfx, "marmots" + sfx, "marmoset" + sfx}, usernames(users))
+	})
+
+	t.Run("Users containing the query are found", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t, testingpg.WithExtensions("pg_trgm"))
+		repo := rootpkg.NewUserRepository(db)
+		sfx := newSuffix()
+		createUsers(t, repo, "marmoset"+sfx, "gopher"+sfx, "Marmot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "ARMO", 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"Marmot" + sfx, "marmoset" + sfx}, usernames(users))
+	})
+
+	t.Run("Number of users is limited", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t, testingpg.WithExtensions("pg_trgm"))
+		repo := rootpkg.NewUserRepository(db)
+		sfx := newSuffix()
+		createUsers(t, repo, "marmoset"+sfx, "marmots"+sfx, "Marmot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "marmot"+sfx, 1)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"Marmot" + sfx}, usernames(users))
+	})
+
+	t.Run("Soft-deleted users are not found", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t, testingpg.WithExtensions("pg_trgm"))
+		repo := rootpkg.NewUserRepository(db)
+		sfx := newSuffix()
+		user := newUser("marmot" + sfx)
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+		require.NoError(t, repo.SoftDeleteUser(tenantContext(), user.ID))
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "marmot"+sfx, 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+
+	t.Run("Wildcards of the query are matched literally", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t, testingpg.WithExtensions("pg_trgm"))
+		repo := rootpkg.NewUserRepository(db)
+		sfx := newSuffix()
+		createUsers(t, repo, "marmot"+sfx, "mar_mot"+sfx)
+
+		// Act
+		users, err := repo.SearchUsers(tenantContext(), "r_m", 10)
+
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []string{"mar_mot" + sfx}, usernames(users))
+	})
+}
+
+func Test_Transactional_UserRepository_CreateUsers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	// newUsers returns users with a unique username prefix in the order of
+	// listing.
+	newUsers := func(count int) (string, []rootpkg.User) {
+		prefix := "batch-" + uuid.NewString()[:8] + "-"
+		createdAt := time.Now().Truncate(time.Microsecond)
+
+		users := make([]rootpkg.User, count)
+		for i := range users {
+			users[i] = rootpkg.User{
+				ID:        uuid.New(),
+				Username:  fmt.Sprintf("%s%d", prefix, i),
+				CreatedAt: createdAt.Add(time.Duration(i) * time.Microsecond),
+			}
+		}
+
+		return prefix, users
+	}
+
+	t.Run("Successfully created many users", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		prefix, users := newUsers(1000)
+
+		// Act
+		err := repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		require.NoError(t, err)
+
+		filter := rootpkg.UserFilter{UsernamePrefix: prefix}
+		page := rootpkg.Page{Size: len(users)}
+
+		gotUsers, _, err := repo.ListUsers(tenantContext(), filter, page)
+		require.NoError(t, err)
+
+		require.Equal(t, users, gotUsers)
+	})
+
+	t.Run("Conflict with an existing user is reported with index", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		_, users := newUsers(3)
+
+		err := repo.CreateUser(tenantContext(), users[1])
+		require.NoError(t, err)
+
+		users[1].ID = uuid.New()
+
+		// Act
+		err = repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		batchErr := &rootpkg.BatchError{}
+		require.ErrorAs(t, err, &batchErr)
+		require.Equal(t, 1, batchErr.Index)
+		require.ErrorIs(t, err, rootpkg.ErrUsernameTaken)
+	})
+
+	t.Run("Duplicated ID in the batch is reported with index", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		_, users := newUsers(3)
+		users[2].ID = users[0].ID
+
+		// Act
+		err := repo.CreateUsers(tenantContext(), users)
+
+		// Assert
+		batchErr := &rootpkg.BatchError{}
+		require.ErrorAs(t, err, &batchErr)
+		require.Equal(t, 2, batchErr.Index)
+		require.ErrorIs(t, err, rootpkg.ErrUserIDConflict)
+	})
+}
+
+func Test_Transactional_InstrumentDB_Statements(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Statements are traced in the transaction of the handle", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		exporter := &rootpkg.MemoryExporter{}
+		repo := rootpkg.NewUserRepository(rootpkg.InstrumentDB(db, rootpkg.WithTracer(exporter)))
+
+		user := rootpkg.User{
+			ID:        uuid.New(),
+			Username:  "instrumented-" + uuid.NewString()[:8],
+			CreatedAt: time.Now(),
+		}
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		names := make([]string, 0)
+
+		for _, span := range exporter.Spans() {
+			names = append(names, span.Name)
+		}
+
+		require.Equal(t, []string{
+			"SAVEPOINT",
+			"SELECT",
+			"INSERT",
+			"SELECT",
+			"RELEASE",
+			"UserRepository.CreateUser",
+		}, names)
+	})
+}
+
+func Test_Transactional_LoggingDB_Statements(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Statements are logged in the transaction of the handle", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+
+		buf := &bytes.Buffer{}
+		handler := slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+		repo := rootpkg.NewUserRepository(rootpkg.NewLoggingDB(db, slog.New(handler)))
+
+		user := rootpkg.User{
+			ID:        uuid.New(),
+			Username:  "logged-" + uuid.NewString()[:8],
+			CreatedAt: time.Now(),
+		}
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.NoError(t, err)
+
+		records := parseLogRecords(t, buf)
+		require.Len(t, records, 1)
+		require.Equal(t, user.Username, records[0].Args[1])
+	})
+}
+
+func Test_Transactional_UserRepository_StatementCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	t.Run("Statement is cached in the transaction of the handle", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+
+		user := rootpkg.User{
+			ID:        uuid.New(),
+			Username:  "prepared-" + uuid.NewString()[:8],
+			CreatedAt: time.Now().Truncate(time.Microsecond),
+		}
+
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		// Act
+		err := repo.CreateUser(tenantContext(), user)
+
+		// Assert
+		require.ErrorIs(t, err, rootpkg.ErrUserIDConflict)
+
+		// The statement is usable after the rollback to the savepoint of the
+		// failed call.
+		other := rootpkg.User{
+			ID:        uuid.New(),
+			Username:  "prepared-" + uuid.NewString()[:8],
+			CreatedAt: time.Now().Truncate(time.Microsecond),
+		}
+		require.NoError(t, repo.CreateUser(tenantContext(), other))
+
+		got, err := repo.ReadUser(tenantContext(), other.ID)
+		require.NoError(t, err)
+		require.Equal(t, other, got)
+
+		require.Equal(t, 1, countCachedStatements(t, db))
+	})
+}
+
+func Test_Transactional_TxManager_WithinTx(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	errTest := errors.New("test error")
+
+	t.Run("Changes are committed if the function succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		txManager := rootpkg.NewTxManager(db)
+		user1 := newFullyFiledUser()
+		user2 := newFullyFiledUser()
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.CreateUser(ctx, user1); err != nil {
+				return err
+			}
+
+			return repo.CreateUser(ctx, user2)
+		})
+
+		// Assert
+		require.NoError(t, err)
+
+		for _, user := range []rootpkg.User{user1, user2} {
+			gotUser, err := repo.ReadUser(tenantContext(), user.ID)
+			require.NoError(t, err)
+			require.Equal(t, user, gotUser)
+		}
+	})
+
+	t.Run("Changes are rolled back if the function fails", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		repo := rootpkg.NewUserRepository(db)
+		txManager := rootpkg.NewTxManager(db)
+		existing := newFullyFiledUser()
+		user := newFullyFiledUser()
+
+		require.NoError(t, repo.CreateUser(tenantContext(), existing))
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(ctx context.Context) error {
+			if err := repo.CreateUser(ctx, user); err != nil {
+				return err
+			}
+
+			return errTest
+		})
+
+		// Assert
+		require.ErrorIs(t, err, errTest)
+
+		_, err = repo.ReadUser(tenantContext(), user.ID)
+		require.ErrorIs(t, err, rootpkg.ErrUserNotFound)
+
+		_, err = repo.ReadUser(tenantContext(), existing.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("Serialization failure is not retried within the outer transaction", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		db := testingpg.NewWithTransactionalCleanup(t)
+		txManager := rootpkg.NewTxManager(db)
+		attempts := 0
+
+		// Act
+		err := txManager.WithinTx(tenantContext(), func(context.Context) error {
+			attempts++
+
+			return &pgconn.PgError{Code: "40001"}
+		})
+
+		// Assert
+		pgErr := &pgconn.PgError{}
+		require.ErrorAs(t, err, &pgErr)
+		require.Equal(t, 1, attempts)
 	})
 }
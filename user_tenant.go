@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns the context with the tenant, UserRepository requires the
+// tenant in the context of every call and sees only users of the tenant.
+func WithTenant(ctx context.Context, tenantID uuid.UUID) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+func tenantFromContext(ctx context.Context) (uuid.UUID, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(uuid.UUID)
+	return tenantID, ok
+}
+
+// The equivalent of SET LOCAL app.tenant_id and SET LOCAL app.actor, which do
+// not accept parameters. The row-level security policies of the users table
+// are keyed on app.tenant_id, the trigger of the audit records app.actor.
+const setScopeSQL = `SELECT set_config('app.tenant_id', $1, true),
+       set_config('app.actor', $2, true);`
+
+// scopeFromContext returns the values of app.tenant_id and app.actor for the
+// context, ErrTenantRequired is returned if the context has no tenant.
+func scopeFromContext(ctx context.Context) (string, string, error) {
+	tenantID, ok := tenantFromContext(ctx)
+	if !ok {
+		return "", "", ErrTenantRequired
+	}
+
+	actor, _ := actorFromContext(ctx)
+
+	return tenantID.String(), actor, nil
+}
+
+// scoped runs fn in a transaction in which app.tenant_id is set to the tenant
+// of the context and app.actor is set to the actor of the context, so that
+// the row-level security policies of the users table limit fn to users of the
+// tenant and the trigger of the users table records who made the change.
+//
+// The method is the name of the method of UserRepository which calls scoped,
+// the call is traced by the database returned by InstrumentDB.
+func (r *UserRepository) scoped(
+	ctx context.Context,
+	method string,
+	fn func(ctx context.Context) error,
+) (err error) {
+	if c, ok := r.db.(caller); ok {
+		var end func(err error)
+
+		ctx, end = c.startCall(ctx, method)
+		defer func() { end(err) }()
+	}
+
+	tenantID, actor, err := scopeFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The transaction is shared with other calls when the call joins the
+	// transaction of TxManager.WithinTx or runs in a savepoint of the handle
+	// which cannot start transactions, then the transaction must not stay
+	// scoped to the tenant and attributed to the actor after the call, also
+	// if it fails. The transaction started by the call ends with it.
+	_, owned := r.db.(beginner)
+	shared := !owned || txFromContext(ctx) != nil
+
+	return NewTxManager(r.db).WithinTx(ctx, func(ctx context.Context) (err error) {
+		_, err = r.conn(ctx).ExecContext(ctx, setScopeSQL, tenantID, actor)
+		if err != nil {
+			return fmt.Errorf("failed setting of tenant: %w", err)
+		}
+
+		if shared {
+			defer func() {
+				// The reset fails if fn aborted the transaction, which then
+				// cannot be used by other calls anyway.
+				_, resetErr := r.conn(ctx).ExecContext(ctx, setScopeSQL, "", "")
+				if err == nil && resetErr != nil {
+					err = fmt.Errorf("failed resetting of tenant: %w", resetErr)
+				}
+			}()
+		}
+
+		return fn(ctx)
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+)
+
+func TestUserRepository_TenantReset(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Tenant is reset in the outer transaction after the failed call", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		exporter := &rootpkg.MemoryExporter{}
+		db := rootpkg.InstrumentDB(execErrorDB{}, rootpkg.WithTracer(exporter))
+		repo := rootpkg.NewUserRepository(db)
+
+		// Act
+		err := rootpkg.NewTxManager(db).WithinTx(tenantContext(), func(ctx context.Context) error {
+			require.ErrorIs(t, repo.DeleteUser(ctx, uuid.New()), rootpkg.ErrUserNotFound)
+			return nil
+		})
+
+		// Assert
+		require.NoError(t, err)
+
+		statements := make([]string, 0)
+
+		for _, span := range exporter.Spans() {
+			if statement, ok := span.Attributes["db.statement"].(string); ok {
+				statements = append(statements, statement)
+			}
+		}
+
+		require.Len(t, statements, 5)
+		require.Contains(t, statements[1], "set_config")
+		require.Contains(t, statements[2], "DELETE FROM users")
+		require.Contains(t, statements[3], "set_config")
+		require.Contains(t, statements[4], "RELEASE")
+	})
+}
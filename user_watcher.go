@@ -0,0 +1,209 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// usersChannel is the channel notified by the trigger of the users table.
+const usersChannel = "users_changes"
+
+// ChangeOp is the operation which changed a user.
+type ChangeOp string
+
+const (
+	ChangeInsert ChangeOp = "INSERT"
+	ChangeUpdate ChangeOp = "UPDATE"
+	ChangeDelete ChangeOp = "DELETE"
+)
+
+// UserChanged is delivered by UserWatcher after the transaction which changed
+// the user is committed.
+type UserChanged struct {
+	Op     ChangeOp  `json:"op"`
+	UserID uuid.UUID `json:"user_id"`
+	// Version is the version of the user after the change, or before the
+	// change for ChangeDelete.
+	Version int64 `json:"version"`
+}
+
+// ConnectFunc opens a dedicated connection, it is called again every time
+// the connection of UserWatcher is lost.
+type ConnectFunc func(ctx context.Context) (*pgx.Conn, error)
+
+type WatcherOption func(o *watcherOptions)
+
+type watcherOptions struct {
+	reconnectDelay time.Duration
+	bufferSize     int
+}
+
+// WithReconnectDelay sets the delay before reconnection after the connection
+// is lost, by default it is 1 second.
+func WithReconnectDelay(delay time.Duration) WatcherOption {
+	return func(o *watcherOptions) {
+		o.reconnectDelay = delay
+	}
+}
+
+// WithBufferSize sets the capacity of the channel of events, by default it
+// is 64. The watcher does not read notifications while the channel is full.
+func WithBufferSize(size int) WatcherOption {
+	return func(o *watcherOptions) {
+		o.bufferSize = max(size, 0)
+	}
+}
+
+const (
+	defaultReconnectDelay = time.Second
+	defaultBufferSize     = 64
+)
+
+func NewUserWatcher(connect ConnectFunc, opts ...WatcherOption) *UserWatcher {
+	options := watcherOptions{
+		reconnectDelay: defaultReconnectDelay,
+		bufferSize:     defaultBufferSize,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &UserWatcher{connect: connect, options: options}
+}
+
+// UserWatcher delivers changes of users by LISTEN on a dedicated connection,
+// the pool of *sql.DB cannot be used because it does not keep the session.
+//
+// Notifications are sent to all sessions of the database, so changes made in
+// other schemas of the same database are delivered too, only changes of other
+// tenants are skipped by the watcher. Changes made while
+// the connection is lost are not delivered, so the consumer which cannot
+// miss changes has to reread users after reconnection.
+type UserWatcher struct {
+	connect ConnectFunc
+	options watcherOptions
+}
+
+// Watch subscribes to changes of users of the tenant of the context and
+// returns the channel of events, ErrTenantRequired is returned if the context
+// has no tenant. The channel is closed when the context is done and the
+// connection is closed, so the caller waits for the shutdown by reading the
+// channel until it is closed. The error is returned if the first subscription
+// fails, later the watcher reconnects and resubscribes until the context is
+// done.
+func (w *UserWatcher) Watch(ctx context.Context) (<-chan UserChanged, error) {
+	tenantID, ok := tenantFromContext(ctx)
+	if !ok {
+		return nil, ErrTenantRequired
+	}
+
+	conn, err := w.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan UserChanged, w.options.bufferSize)
+
+	go func() {
+		defer close(events)
+
+		for {
+			if conn != nil {
+				w.receive(ctx, conn, tenantID, events)
+				conn = nil
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			timer := time.NewTimer(w.options.reconnectDelay)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			// The error is not reported, the next attempt is made after the
+			// delay.
+			conn, _ = w.subscribe(ctx)
+		}
+	}()
+
+	return events, nil
+}
+
+func (w *UserWatcher) subscribe(ctx context.Context) (*pgx.Conn, error) {
+	const format = "failed subscription to changes of Users: %w"
+
+	conn, err := w.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(format, err)
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+usersChannel+";")
+	if err != nil {
+		closeConn(conn)
+		return nil, fmt.Errorf(format, err)
+	}
+
+	return conn, nil
+}
+
+// receive sends events of the tenant to the channel until the context is done
+// or the connection is lost, the connection is closed on return.
+func (w *UserWatcher) receive(
+	ctx context.Context,
+	conn *pgx.Conn,
+	tenantID uuid.UUID,
+	events chan<- UserChanged,
+) {
+	defer closeConn(conn)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return
+		}
+
+		payload := struct {
+			UserChanged
+
+			TenantID uuid.UUID `json:"tenant_id"`
+		}{}
+
+		// The payload is built by the trigger, so it is always valid.
+		if err := json.Unmarshal([]byte(notification.Payload), &payload); err != nil {
+			continue
+		}
+
+		// Changes of all tenants are notified to the same channel.
+		if payload.TenantID != tenantID {
+			continue
+		}
+
+		select {
+		case events <- payload.UserChanged:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func closeConn(conn *pgx.Conn) {
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+
+	_ = conn.Close(ctx)
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2024 Vasiliy Vasilyuk. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testing_go_code_with_postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+
+	rootpkg "github.com/xorcare/testing-go-code-with-postgres"
+	"github.com/xorcare/testing-go-code-with-postgres/testingpg"
+)
+
+func TestUserWatcher_Watch_TenantRequired(t *testing.T) {
+	t.Parallel()
+
+	// Arrange
+	watcher := rootpkg.NewUserWatcher(func(context.Context) (*pgx.Conn, error) {
+		return nil, errors.New("unexpected connection")
+	})
+
+	// Act
+	events, err := watcher.Watch(context.Background())
+
+	// Assert
+	require.ErrorIs(t, err, rootpkg.ErrTenantRequired)
+	require.Nil(t, events)
+}
+
+func TestUserWatcher_Watch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	t.Parallel()
+
+	receive := func(t *testing.T, events <-chan rootpkg.UserChanged) rootpkg.UserChanged {
+		t.Helper()
+
+		select {
+		case event, ok := <-events:
+			require.True(t, ok, "channel of events is closed")
+			return event
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "timeout of waiting for the event")
+			return rootpkg.UserChanged{}
+		}
+	}
+
+	// stop waits for the shutdown of the watcher, so that the connection is
+	// closed before the database is dropped.
+	stop := func(done context.CancelFunc, events <-chan rootpkg.UserChanged) {
+		done()
+
+		for range events {
+		}
+	}
+
+	t.Run("Changes of a user are delivered in order", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		watcher := rootpkg.NewUserWatcher(postgres.Conn)
+
+		ctx, done := context.WithCancel(tenantContext())
+
+		events, err := watcher.Watch(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { stop(done, events) })
+
+		user := newFullyFiledUser()
+
+		// Act
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		user.Username = newFullyFiledUser().Username
+		require.NoError(t, repo.UpdateUser(tenantContext(), user))
+
+		require.NoError(t, repo.DeleteUser(tenantContext(), user.ID))
+
+		// Assert
+		want := []rootpkg.UserChanged{
+			{Op: rootpkg.ChangeInsert, UserID: user.ID, Version: 0},
+			{Op: rootpkg.ChangeUpdate, UserID: user.ID, Version: 1},
+			{Op: rootpkg.ChangeDelete, UserID: user.ID, Version: 1},
+		}
+
+		for _, wantEvent := range want {
+			require.Equal(t, wantEvent, receive(t, events))
+		}
+	})
+
+	t.Run("Changes of other tenants are not delivered", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		watcher := rootpkg.NewUserWatcher(postgres.Conn)
+
+		ctx, done := context.WithCancel(tenantContext())
+
+		events, err := watcher.Watch(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { stop(done, events) })
+
+		anotherCtx := rootpkg.WithTenant(context.Background(), uuid.New())
+		user := newFullyFiledUser()
+
+		// Act
+		require.NoError(t, repo.CreateUser(anotherCtx, newFullyFiledUser()))
+		require.NoError(t, repo.CreateUser(tenantContext(), user))
+
+		// Assert
+		want := rootpkg.UserChanged{Op: rootpkg.ChangeInsert, UserID: user.ID, Version: 0}
+		require.Equal(t, want, receive(t, events))
+	})
+
+	t.Run("Watcher resubscribes after the connection is lost", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		repo := rootpkg.NewUserRepository(postgres.DB())
+		watcher := rootpkg.NewUserWatcher(
+			postgres.Conn,
+			rootpkg.WithReconnectDelay(10*time.Millisecond),
+		)
+
+		ctx, done := context.WithCancel(tenantContext())
+
+		events, err := watcher.Watch(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { stop(done, events) })
+
+		// Act
+		var terminated bool
+		err = postgres.DB().QueryRowContext(
+			tenantContext(),
+			`SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+WHERE datname = current_database() AND query = 'LISTEN users_changes;';`,
+		).Scan(&terminated)
+		require.NoError(t, err)
+		require.True(t, terminated)
+
+		// Assert
+		// Changes made before the resubscription are lost, so users are
+		// created until one of them is delivered.
+		created := map[uuid.UUID]bool{}
+
+		require.Eventually(t, func() bool {
+			select {
+			case event := <-events:
+				if created[event.UserID] {
+					return true
+				}
+			default:
+			}
+
+			user := newFullyFiledUser()
+			if err := repo.CreateUser(tenantContext(), user); err == nil {
+				created[user.ID] = true
+			}
+
+			return false
+		}, 10*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Channel is closed when the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		// Arrange
+		postgres := testingpg.NewWithIsolatedDatabase(t, testingpg.WithDedicatedRole("app"))
+		watcher := rootpkg.NewUserWatcher(postgres.Conn)
+
+		ctx, done := context.WithCancel(tenantContext())
+
+		events, err := watcher.Watch(ctx)
+		require.NoError(t, err)
+
+		// Act
+		done()
+
+		// Assert
+		require.Eventually(t, func() bool {
+			select {
+			case _, ok := <-events:
+				return !ok
+			default:
+				return false
+			}
+		}, 10*time.Second, 10*time.Millisecond)
+	})
+}